@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/internal/manager"
+	"github.com/spf13/pflag"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// bench measures the throughput of a DataSource's config against a no-op runtime, and returns the exit code.
+//
+// Usage: streaming bench -f datasource.yaml -f features.yaml --payloads messages.jsonl [--messages 10000]
+func bench(args []string) int {
+	fs := pflag.NewFlagSet("bench", pflag.ExitOnError)
+	files := fs.StringSliceP("filename", "f", nil, "Manifests of the DataSource and its Features (multi-document YAML is supported)")
+	payloads := fs.String("payloads", "", "A file of sample message bodies, one per line")
+	messages := fs.Int("messages", 10000, "The number of messages to handle")
+	topic := fs.String("topic", "bench", "The topic the messages are attributed to")
+	workers := fs.Int("workers", 0, "Override the DataSource's workers. Set to `0` to use the DataSource's")
+	maxConcurrentExec := fs.Int("max-concurrent-exec", 0, "The maximum number of concurrent program executions. Set to `0` for no limit")
+	latency := fs.Duration("runtime-latency", 0, "The simulated latency of each program execution")
+	_ = fs.Parse(args)
+
+	in, features, err := loadManifests(*files)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	bodies, err := readPayloads(*payloads)
+	if err != nil {
+		fmt.Printf("failed to read payloads: %s\n", err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	res, err := manager.Bench(ctx, offlineConfig(in), features, manager.BenchConfig{
+		Messages:          *messages,
+		Payloads:          bodies,
+		Topic:             *topic,
+		Workers:           *workers,
+		MaxConcurrentExec: *maxConcurrentExec,
+		RuntimeLatency:    *latency,
+	})
+	if err != nil {
+		fmt.Printf("benchmark failed: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("handled %d message(s) in %s: %.1f msg/s\n", res.Messages, res.Duration.Round(time.Millisecond), res.Throughput())
+	fmt.Printf("latency p50=%s p90=%s p99=%s\n", res.Latencies[50], res.Latencies[90], res.Latencies[99])
+	if res.Failed > 0 {
+		fmt.Printf("%d message(s) failed, i.e.: %s\n", res.Failed, res.Err)
+		return 1
+	}
+	return 0
+}
+
+// readPayloads reads the non-empty lines of the file
+func readPayloads(path string) ([][]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--payloads is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret [][]byte
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16*1024*1024)
+	for s.Scan() {
+		if line := bytes.TrimSpace(s.Bytes()); len(line) > 0 {
+			ret = append(ret, bytes.Clone(line))
+		}
+	}
+	return ret, s.Err()
+}
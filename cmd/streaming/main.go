@@ -18,28 +18,39 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
 	"github.com/raptor-ml/raptor/pkg/runtimemanager"
 	_ "github.com/raptor-ml/streaming-runner/internal/brokers"
 	"github.com/raptor-ml/streaming-runner/internal/manager"
+	_ "github.com/raptor-ml/streaming-runner/internal/transforms"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"strings"
 	"syscall"
+	"time"
 )
 
-// version is being overridden in build time
-var version = "master"
+// version and commit are being overridden in build time
+var (
+	version = "master"
+	commit  = ""
+)
 
 var setupLog logr.Logger
 
@@ -51,15 +62,62 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(bench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		os.Exit(replayDLQ(os.Args[2:]))
+	}
+
+	printVersion := pflag.Bool("version", false, "Print the version and exit")
 	pflag.Bool("production", true, "Set as production")
 	pflag.String("data-source-resource", "", "The resource name of the DataSource")
 	pflag.String("data-source-namespace", "", "The namespace name of the DataSource")
+	pflag.String("metrics-bind-address", ":8080", "The address the metric endpoint binds to. Set to `0` to disable")
+	pflag.String("admin-bind-address", ":8081", "The address the admin and health endpoints bind to. Set to `0` to disable")
+	pflag.String("admin-token", "", "A bearer token required by the admin control endpoints (pause, resume and drain). Without a token, they're served to local requests only")
+	pflag.String("admin-token-file", "", "A file containing the bearer token required by the admin control endpoints. The file is re-read when modified")
+	pflag.Uint32("runtime-breaker-threshold", 5, "Consecutive runtime failures before opening the circuit breaker. Set to `0` to disable")
+	pflag.Duration("runtime-breaker-timeout", 30*time.Second, "The time the runtime circuit breaker stays open before probing the runtime again")
+	pflag.String("runtime-auth-token", "", "A bearer token to authenticate with the runtime")
+	pflag.String("runtime-auth-token-file", "", "A file containing a bearer token to authenticate with the runtime. The file is re-read when modified")
+	pflag.String("feature-selector", "", "A label selector that limits the features to the matching ones")
+	pflag.Duration("warmup-delay", 0, "A delay before receiving the first messages, to let the runtime warm up. Trades startup latency for fewer failures of the first messages")
+	pflag.Int("max-concurrent-exec", 0, "The maximum number of concurrent program executions across workers and features. Set to `0` for no limit")
+	pflag.Int("runtime-mismatch-retries", 2, "Times to retry a runtime call whose response doesn't match the request's uuid. Set to `0` to disable")
+	pflag.Bool("log-exec-results", false, "Log the results of the programs' executions at the debug level. The results may contain sensitive data")
+	pflag.Int("log-exec-results-max-length", 256, "Truncate the logged execution results to this length. Set to `0` to disable truncation")
+	pflag.Bool("skip-schema-registration", false, "Skip registering the schemas on startup, for schemas managed out-of-band. The schemas are registered lazily by the first message instead")
+	pflag.Duration("idle-resubscribe-timeout", 0, "Resubscribe when no message was received on a topic for this long. Set to `0` to disable, i.e. for legitimately idle topics")
+	pflag.Duration("feature-refresh-interval", 0, "Periodically reconcile the features with the current Features, to recover from missed events. Set to `0` to disable")
+	pflag.Duration("shutdown-timeout", 30*time.Second, "The maximum time to wait for the subscriptions to shut down. Should be shorter than the termination grace period")
+	pflag.Int("program-cache-size", 0, "The number of loaded programs to cache, skipping reloading unchanged programs into the runtime. Set to `0` to disable")
+	pflag.Duration("schema-cache-ttl", 0, "The duration registered schemas are cached, skipping registering a schema shared by several features again. Set to `0` to disable")
+	pflag.Bool("metrics-feature-label", true, "Label the per-feature metrics by the feature's FQN")
+	pflag.Int("metrics-feature-label-limit", manager.DefaultFeatureLabelsLimit, "The maximum number of distinct features labeled in the metrics. Features beyond the limit are labeled as `other`")
 	pflag.Parse()
 	must(viper.BindPFlags(pflag.CommandLine))
 
+	bi := buildInfo()
+	if *printVersion {
+		fmt.Println(bi)
+		return
+	}
+	manager.ExportBuildInfo(bi)
+
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
+	if viper.GetBool("metrics-feature-label") {
+		manager.LimitFeatureLabels(viper.GetInt("metrics-feature-label-limit"))
+	} else {
+		manager.LimitFeatureLabels(0)
+	}
+	manager.CacheSchemas(viper.GetDuration("schema-cache-ttl"))
+
 	zl := logger()
 	logger := zapr.NewLogger(zl)
 	setupLog = logger.WithName("setup")
@@ -70,22 +128,105 @@ func main() {
 
 	rm, err := runtimemanager.New(nil, "", "")
 	must(err)
+	if f := viper.GetString("runtime-auth-token-file"); f != "" {
+		rm = manager.WithAuthToken(rm, manager.FileToken(f))
+	} else if t := viper.GetString("runtime-auth-token"); t != "" {
+		rm = manager.WithAuthToken(rm, manager.StaticToken(t))
+	}
+	rm = manager.WithProgramCache(rm, viper.GetInt("program-cache-size"))
+	rm = manager.WithMismatchRetry(rm, viper.GetInt("runtime-mismatch-retries"))
+	rm = manager.WithConcurrencyLimit(rm, viper.GetInt("max-concurrent-exec"))
+	rm = manager.WithCircuitBreaker(rm, viper.GetUint32("runtime-breaker-threshold"),
+		viper.GetDuration("runtime-breaker-timeout"), logger.WithName("breaker"))
 
 	src := client.ObjectKey{
 		Name:      viper.GetString("data-source-resource"),
 		Namespace: viper.GetString("data-source-namespace"),
 	}
-	mgr, err := manager.New(src, rm, ctrl.GetConfigOrDie(), logger.WithName("manager"))
+	var opts []manager.Option
+	if s := viper.GetString("feature-selector"); s != "" {
+		selector, err := labels.Parse(s)
+		must(err)
+		opts = append(opts, manager.WithFeatureSelector(selector))
+	}
+	if d := viper.GetDuration("warmup-delay"); d > 0 {
+		opts = append(opts, manager.WithWarmupDelay(d))
+	}
+	if viper.GetBool("skip-schema-registration") {
+		opts = append(opts, manager.WithoutSchemaRegistration())
+	}
+	if d := viper.GetDuration("idle-resubscribe-timeout"); d > 0 {
+		opts = append(opts, manager.WithIdleResubscribe(d))
+	}
+	if d := viper.GetDuration("feature-refresh-interval"); d > 0 {
+		opts = append(opts, manager.WithFeatureRefresh(d))
+	}
+	if d := viper.GetDuration("shutdown-timeout"); d > 0 {
+		opts = append(opts, manager.WithShutdownTimeout(d))
+	}
+	if viper.GetBool("log-exec-results") {
+		opts = append(opts, manager.WithExecResultsLogging(viper.GetInt("log-exec-results-max-length")))
+	}
+	mgr, err := manager.New(src, rm, ctrl.GetConfigOrDie(), logger.WithName("manager"), opts...)
 	must(err)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	setupLog.Info("Starting streaming-runner", "version", version)
+	// dump the state on SIGUSR1, for environments where the admin endpoint isn't exposed
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGUSR1)
+	go func() {
+		for range dump {
+			mgr.Dump()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	serve("metrics", viper.GetString("metrics-bind-address"), mux)
+	var adminToken manager.TokenSource
+	if f := viper.GetString("admin-token-file"); f != "" {
+		adminToken = manager.FileToken(f)
+	} else if t := viper.GetString("admin-token"); t != "" {
+		adminToken = manager.StaticToken(t)
+	}
+	serve("admin", viper.GetString("admin-bind-address"), manager.AdminHandler(mgr, bi, adminToken))
+
+	setupLog.Info("Starting streaming-runner", "version", bi.Version, "commit", bi.Commit)
 	err = mgr.Start(ctx)
 	must(err)
 	defer cancel()
 
 }
+func serve(name, addr string, handler http.Handler) {
+	if addr == "" || addr == "0" {
+		return
+	}
+
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			setupLog.Error(err, "failed to serve", "server", name)
+		}
+	}()
+}
+
+// buildInfo returns the build info, falling back to the vcs revision stamped by the go toolchain for the commit
+func buildInfo() manager.BuildInfo {
+	bi := manager.BuildInfo{Version: version, Commit: commit}
+	if bi.Commit == "" {
+		bi.Commit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					bi.Commit = s.Value
+				}
+			}
+		}
+	}
+	return bi
+}
+
 func logger() *zap.Logger {
 	var l *zap.Logger
 	var err error
@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"gocloud.dev/pubsub"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// replayDLQ republishes the messages of a dead-letter subscription to the original topic, once the cause of their
+// failure is fixed, and returns the exit code. The subscription and topic are gocloud urls
+// (i.e. `kafka://group?topic=dlq` and `kafka://topic`).
+//
+// Usage: streaming replay-dlq --from <subscription url> --to <topic url> [--max 100] [--dry-run]
+func replayDLQ(args []string) int {
+	fs := pflag.NewFlagSet("replay-dlq", pflag.ExitOnError)
+	from := fs.String("from", "", "The url of the dead-letter subscription")
+	to := fs.String("to", "", "The url of the topic to republish the messages to")
+	maxMessages := fs.Int("max", 0, "The maximum number of messages to replay. Set to `0` for no limit")
+	idle := fs.Duration("idle-timeout", 10*time.Second, "Stop once no message was received for this long")
+	dryRun := fs.Bool("dry-run", false, "Print the messages without republishing or acking them")
+	_ = fs.Parse(args)
+
+	if *from == "" || (*to == "" && !*dryRun) {
+		fmt.Println("--from and --to are required")
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	sub, err := pubsub.OpenSubscription(ctx, *from)
+	if err != nil {
+		fmt.Printf("failed to open the dead-letter subscription: %s\n", err)
+		return 1
+	}
+	defer sub.Shutdown(context.Background())
+
+	var topic *pubsub.Topic
+	if !*dryRun {
+		topic, err = pubsub.OpenTopic(ctx, *to)
+		if err != nil {
+			fmt.Printf("failed to open the topic: %s\n", err)
+			return 1
+		}
+		defer topic.Shutdown(context.Background())
+	}
+
+	n, err := replay(ctx, sub, topic, *maxMessages, *idle)
+	if *dryRun {
+		fmt.Printf("found %d message(s)\n", n)
+	} else {
+		fmt.Printf("replayed %d message(s)\n", n)
+	}
+	if err != nil {
+		fmt.Printf("replay stopped: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// replay republishes the messages of the subscription to the topic, acking each once it's republished.
+// When the topic is nil (a dry run), the messages are only printed.
+func replay(ctx context.Context, sub *pubsub.Subscription, topic *pubsub.Topic, maxMessages int, idle time.Duration) (int, error) {
+	n := 0
+	for maxMessages == 0 || n < maxMessages {
+		rctx, cancel := context.WithTimeout(ctx, idle)
+		msg, err := sub.Receive(rctx)
+		cancel()
+		if err != nil {
+			if errors.Is(rctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				return n, nil
+			}
+			return n, err
+		}
+
+		if topic == nil {
+			// the messages are left unacked, rather than nacked, so they aren't redelivered during the dry run
+			fmt.Printf("%s %v %s\n", msg.LoggableID, msg.Metadata, msg.Body)
+			n++
+			continue
+		}
+
+		err = topic.Send(ctx, &pubsub.Message{Body: msg.Body, Metadata: msg.Metadata})
+		if err != nil {
+			if msg.Nackable() {
+				msg.Nack()
+			}
+			return n, fmt.Errorf("failed to republish %s: %w", msg.LoggableID, err)
+		}
+		msg.Ack()
+		n++
+	}
+	return n, nil
+}
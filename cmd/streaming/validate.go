@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/internal/manager"
+	"github.com/spf13/pflag"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+)
+
+// validate validates DataSource and Feature manifests offline, and returns the exit code.
+//
+// Usage: streaming validate -f datasource.yaml [-f features.yaml ...]
+func validate(args []string) int {
+	fs := pflag.NewFlagSet("validate", pflag.ExitOnError)
+	files := fs.StringSliceP("filename", "f", nil, "Manifests of the DataSource and its Features (multi-document YAML is supported)")
+	_ = fs.Parse(args)
+
+	in, features, err := loadManifests(*files)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	cfg := offlineConfig(in)
+
+	errs := manager.Validate(in, cfg, features)
+	if len(errs) > 0 {
+		fmt.Printf("DataSource %s/%s is invalid:\n", in.Namespace, in.Name)
+		for _, err := range errs {
+			fmt.Printf("  - %s\n", err)
+		}
+		return 1
+	}
+
+	fmt.Printf("DataSource %s/%s and %d feature(s) are valid\n", in.Namespace, in.Name, len(features))
+	return 0
+}
+
+// loadManifests reads the DataSource and its Features from the manifest files
+func loadManifests(files []string) (*raptorApi.DataSource, []*raptorApi.Feature, error) {
+	var in *raptorApi.DataSource
+	var features []*raptorApi.Feature
+	for _, f := range files {
+		err := readManifests(f, func(kind string, raw json.RawMessage) error {
+			switch kind {
+			case "DataSource":
+				if in != nil {
+					return fmt.Errorf("only a single DataSource can be loaded at a time")
+				}
+				in = &raptorApi.DataSource{}
+				return json.Unmarshal(raw, in)
+			case "Feature":
+				ft := &raptorApi.Feature{}
+				if err := json.Unmarshal(raw, ft); err != nil {
+					return err
+				}
+				// the builder's custom configuration is inlined in the builder
+				var spec struct {
+					Spec struct {
+						Builder json.RawMessage `json:"builder"`
+					} `json:"spec"`
+				}
+				if err := json.Unmarshal(raw, &spec); err != nil {
+					return err
+				}
+				ft.Spec.Builder.Raw = spec.Spec.Builder
+				features = append(features, ft)
+			default:
+				fmt.Printf("skipping unsupported kind: %s\n", kind)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+	}
+	if in == nil {
+		return nil, nil, fmt.Errorf("no DataSource found")
+	}
+	return in, features, nil
+}
+
+// offlineConfig parses the DataSource's config without reading Secrets. Secret references are reported and left out
+// of the config.
+func offlineConfig(in *raptorApi.DataSource) raptorApi.ParsedConfig {
+	cfg := make(raptorApi.ParsedConfig)
+	for _, cv := range in.Spec.Config {
+		if cv.SecretKeyRef != nil {
+			fmt.Printf("config %s: skipping secret reference %s/%s\n", cv.Name, cv.SecretKeyRef.Name, cv.SecretKeyRef.Key)
+			continue
+		}
+		cfg[cv.Name] = cv.Value
+	}
+	return cfg
+}
+
+// readManifests reads the YAML/JSON documents of the file, and calls fn with the kind and JSON of each document
+func readManifests(path string, fn func(kind string, raw json.RawMessage) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		var tm metav1.TypeMeta
+		if err := json.Unmarshal(raw, &tm); err != nil {
+			return err
+		}
+		if err := fn(tm.Kind, raw); err != nil {
+			return fmt.Errorf("%s: %w", tm.Kind, err)
+		}
+	}
+}
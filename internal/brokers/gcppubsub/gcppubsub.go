@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 
+	raw "cloud.google.com/go/pubsub/apiv1"
 	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
 	"github.com/raptor-ml/raptor/api/v1alpha1"
 	"github.com/raptor-ml/streaming-runner/pkg/brokers"
@@ -27,6 +28,7 @@ import (
 	"gocloud.dev/pubsub"
 	"gocloud.dev/pubsub/gcppubsub"
 	"golang.org/x/oauth2/google"
+	"time"
 )
 
 func init() {
@@ -36,35 +38,60 @@ func init() {
 type provider struct{}
 type ContextKey string
 
-const TopicContextKey ContextKey = "topic"
 const ProjectIDContextKey ContextKey = "project_id"
 
-func (p *provider) Metadata(ctx context.Context, msg *pubsub.Message) brokers.Metadata {
+// Metadata extracts the message metadata.
+// The topic is populated by the manager, since each subscription is bound to a single topic.
+func (p *provider) Metadata(_ context.Context, msg *pubsub.Message) brokers.Metadata {
 	var md brokers.Metadata
 	var m *pb.PubsubMessage
 	if ok := msg.As(&m); ok {
 		md.Timestamp = m.GetPublishTime().AsTime()
 		md.ID = m.GetMessageId()
-		md.Topic = ctx.Value(TopicContextKey).(string)
+	}
+	// the delivery attempts are tracked only for subscriptions with a dead letter policy
+	var rm *pb.ReceivedMessage
+	if ok := msg.As(&rm); ok {
+		md.Attempts = int(rm.GetDeliveryAttempt())
 	}
 	return md
 }
 
 type config struct {
-	ProjectID      string `mapstructure:"project_id"`
-	Topic          string `mapstructure:"topic"`
-	CredentialJSON []byte `mapstructure:"credential_json,omitempty"`
-	MaxBatchSize   int    `mapstructure:"max_batch_size"`
+	ProjectID      string   `mapstructure:"project_id"`
+	Topic          string   `mapstructure:"topic"`
+	Topics         []string `mapstructure:"topics"`
+	CredentialJSON []byte   `mapstructure:"credential_json,omitempty"`
+	MaxBatchSize   int      `mapstructure:"max_batch_size"`
+
+	// RetryDelay is shared with the manager's config. When set, Nack is lazy, and the redelivery delay is set
+	// explicitly using NackWithDelay.
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
+
+	// MaxOutstanding is shared with the manager's config, and bounds the received batch
+	MaxOutstanding int `mapstructure:"max_outstanding"`
+
+	// SubscriptionOptions supports:
+	//   - receive.max.handlers, receive.min.batch.size, receive.max.batch.size
+	//   - ack.max.handlers, ack.min.batch.size, ack.max.batch.size
+	SubscriptionOptions []string `mapstructure:"subscription_options"`
 }
 
-func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, *pubsub.Subscription, error) {
+func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, []brokers.Subscription, error) {
 	cfg := config{}
 	err := c.Unmarshal(&cfg)
 	if err != nil {
 		return ctx, nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	ctx = context.WithValue(context.WithValue(ctx, TopicContextKey, cfg.Topic), ProjectIDContextKey, cfg.ProjectID)
+	if cfg.Topic != "" {
+		cfg.Topics = append([]string{cfg.Topic}, cfg.Topics...)
+	}
+	if len(cfg.Topics) == 0 {
+		return ctx, nil, fmt.Errorf("topic required to connect to gcp pubsub")
+	}
+
+	ctx = context.WithValue(ctx, ProjectIDContextKey, cfg.ProjectID)
 
 	var creds *google.Credentials
 	if cfg.CredentialJSON != nil {
@@ -99,9 +126,82 @@ func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (cont
 		_ = subClient.Close()
 	}()
 
-	sub, err := gcppubsub.OpenSubscriptionByPath(subClient,
-		fmt.Sprintf("projects/%s/subscriptions/%s", cfg.ProjectID, cfg.Topic),
-		&gcppubsub.SubscriptionOptions{MaxBatchSize: cfg.MaxBatchSize},
-	)
-	return ctx, sub, err
+	subOpts := &gcppubsub.SubscriptionOptions{MaxBatchSize: cfg.MaxBatchSize, NackLazy: cfg.RetryDelay > 0}
+	if cfg.MaxOutstanding > 0 {
+		subOpts.ReceiveBatcherOptions.MaxHandlers = 1
+		subOpts.ReceiveBatcherOptions.MaxBatchSize = cfg.MaxOutstanding
+	}
+	opts, err := brokers.ParseSubscriptionOptions(cfg.SubscriptionOptions)
+	if err != nil {
+		return ctx, nil, err
+	}
+	err = opts.Apply(ctx, map[string]func(string) error{
+		"receive.max.handlers":   brokers.IntOption(&subOpts.ReceiveBatcherOptions.MaxHandlers),
+		"receive.min.batch.size": brokers.IntOption(&subOpts.ReceiveBatcherOptions.MinBatchSize),
+		"receive.max.batch.size": brokers.IntOption(&subOpts.ReceiveBatcherOptions.MaxBatchSize),
+		"ack.max.handlers":       brokers.IntOption(&subOpts.AckBatcherOptions.MaxHandlers),
+		"ack.min.batch.size":     brokers.IntOption(&subOpts.AckBatcherOptions.MinBatchSize),
+		"ack.max.batch.size":     brokers.IntOption(&subOpts.AckBatcherOptions.MaxBatchSize),
+	})
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	// Open a subscription per topic, all sharing the same connection.
+	var subs []brokers.Subscription
+	for _, topic := range cfg.Topics {
+		path := fmt.Sprintf("projects/%s/subscriptions/%s", cfg.ProjectID, topic)
+		sub, err := gcppubsub.OpenSubscriptionByPath(subClient, path, subOpts)
+		if err != nil {
+			for _, s := range subs {
+				_ = s.Shutdown(ctx)
+			}
+			return ctx, nil, fmt.Errorf("failed to open subscription for %s: %w", topic, err)
+		}
+		s := brokers.Subscription{Subscription: sub, Topic: topic, Ping: ping(subClient, path),
+			ExtendDeadline: extendDeadline(subClient, path)}
+		if cfg.RetryDelay > 0 {
+			s.NackWithDelay = nackWithDelay(subClient, path)
+		}
+		subs = append(subs, s)
+	}
+	return ctx, subs, nil
+}
+
+// maxAckDeadline is the maximum ack deadline supported by GCP Pub/Sub
+const maxAckDeadline = 600 * time.Second
+
+// nackWithDelay extends the ack deadline of the message to the delay, and let the lazy Nack release it.
+func nackWithDelay(client *raw.SubscriberClient, path string) func(context.Context, *pubsub.Message, time.Duration) error {
+	extend := extendDeadline(client, path)
+	return func(ctx context.Context, msg *pubsub.Message, delay time.Duration) error {
+		defer msg.Nack()
+		return extend(ctx, msg, delay)
+	}
+}
+
+// extendDeadline modifies the ack deadline of the message to d from now
+func extendDeadline(client *raw.SubscriberClient, path string) func(context.Context, *pubsub.Message, time.Duration) error {
+	return func(ctx context.Context, msg *pubsub.Message, d time.Duration) error {
+		var rm *pb.ReceivedMessage
+		if !msg.As(&rm) {
+			return fmt.Errorf("failed to access the received message")
+		}
+		if d > maxAckDeadline {
+			d = maxAckDeadline
+		}
+		return client.ModifyAckDeadline(ctx, &pb.ModifyAckDeadlineRequest{
+			Subscription:       path,
+			AckIds:             []string{rm.GetAckId()},
+			AckDeadlineSeconds: int32(d.Seconds()),
+		})
+	}
+}
+
+// ping verifies the subscription exists and is accessible
+func ping(client *raw.SubscriberClient, path string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := client.GetSubscription(ctx, &pb.GetSubscriptionRequest{Subscription: path})
+		return err
+	}
 }
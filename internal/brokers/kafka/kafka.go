@@ -26,7 +26,7 @@ import (
 	"github.com/raptor-ml/streaming-runner/pkg/brokers"
 	"gocloud.dev/pubsub"
 	"gocloud.dev/pubsub/kafkapubsub"
-	"strconv"
+	"slices"
 	"strings"
 )
 
@@ -42,7 +42,9 @@ func (p *provider) Metadata(_ context.Context, msg *pubsub.Message) brokers.Meta
 	if ok := msg.As(&m); ok {
 		md.Timestamp = m.Timestamp
 		md.Topic = m.Topic
-		md.ID = strconv.FormatInt(m.Offset, 10)
+		md.ID = fmt.Sprintf("%d/%d", m.Partition, m.Offset)
+		md.Key = brokers.EncodeKey(m.Key)
+		md.KeyBytes = m.Key
 	}
 	return md
 }
@@ -62,11 +64,30 @@ type config struct {
 	TLSClientCert string `mapstructure:"tls_client_cert"`
 	TLSClientKey  string `mapstructure:"tls_client_key"`
 
+	// InitialOffset (or its alias OffsetReset) is where the consumer group starts when it has no committed offset:
+	// `oldest`/`earliest` or `newest`/`latest` (default). It has no effect once the group has committed offsets.
 	InitialOffset string `mapstructure:"initial_offset"`
+	OffsetReset   string `mapstructure:"offset_reset"`
 	Version       string `mapstructure:"version"`
+
+	// MaxOutstanding is shared with the manager's config, and bounds the consumer's channel buffer
+	MaxOutstanding int `mapstructure:"max_outstanding"`
+
+	// RebalanceStrategy is the consumer group's partition assignment strategy: `range` (default), `roundrobin` or
+	// `sticky`. Sticky keeps the partitions assigned across rebalances when replicas scale, which avoids reassigning
+	// partitions whose messages are still held by the workers. The strategy doesn't depend on the number of workers,
+	// since all the workers of a replica share its assigned partitions.
+	RebalanceStrategy string `mapstructure:"rebalance_strategy"`
+
+	// SubscriptionOptions supports:
+	//   - fetch.min.bytes, fetch.default.bytes, fetch.max.bytes
+	//   - max.wait.time, max.processing.time
+	//   - session.timeout, heartbeat.interval, rebalance.timeout
+	//   - channel.buffer.size
+	SubscriptionOptions []string `mapstructure:"subscription_options"`
 }
 
-func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, *pubsub.Subscription, error) {
+func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, []brokers.Subscription, error) {
 	cfg := config{}
 	err := c.Unmarshal(&cfg)
 	if err != nil {
@@ -102,15 +123,48 @@ func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (cont
 		}
 	}
 
-	if io, err := parseInitialOffset(cfg.InitialOffset); err != nil {
-		config.Consumer.Offsets.Initial = io
+	if cfg.InitialOffset == "" {
+		cfg.InitialOffset = cfg.OffsetReset
+	}
+	config.Consumer.Offsets.Initial, err = parseInitialOffset(cfg.InitialOffset)
+	if err != nil {
+		return ctx, nil, err
 	}
 
+	strategy, err := parseRebalanceStrategy(cfg.RebalanceStrategy)
+	if err != nil {
+		return ctx, nil, err
+	}
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+
 	cfg.ClientID = "consumer.k8s.raptor.ml"
 	if cfg.ClientID != "" {
 		config.ClientID = cfg.ClientID
 	}
 
+	if cfg.MaxOutstanding > 0 {
+		config.ChannelBufferSize = cfg.MaxOutstanding
+	}
+
+	opts, err := brokers.ParseSubscriptionOptions(cfg.SubscriptionOptions)
+	if err != nil {
+		return ctx, nil, err
+	}
+	err = opts.Apply(ctx, map[string]func(string) error{
+		"fetch.min.bytes":     brokers.Int32Option(&config.Consumer.Fetch.Min),
+		"fetch.default.bytes": brokers.Int32Option(&config.Consumer.Fetch.Default),
+		"fetch.max.bytes":     brokers.Int32Option(&config.Consumer.Fetch.Max),
+		"max.wait.time":       brokers.DurationOption(&config.Consumer.MaxWaitTime),
+		"max.processing.time": brokers.DurationOption(&config.Consumer.MaxProcessingTime),
+		"session.timeout":     brokers.DurationOption(&config.Consumer.Group.Session.Timeout),
+		"heartbeat.interval":  brokers.DurationOption(&config.Consumer.Group.Heartbeat.Interval),
+		"rebalance.timeout":   brokers.DurationOption(&config.Consumer.Group.Rebalance.Timeout),
+		"channel.buffer.size": brokers.IntOption(&config.ChannelBufferSize),
+	})
+	if err != nil {
+		return ctx, nil, err
+	}
+
 	err = updateTLSConfig(config, cfg)
 	if err != nil {
 		return ctx, nil, err
@@ -126,14 +180,57 @@ func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (cont
 	sub, err := kafkapubsub.OpenSubscription(cfg.Brokers, config, cfg.ConsumerGroup, cfg.Topics, &kafkapubsub.SubscriptionOptions{
 		KeyName: "key",
 	})
-	return ctx, sub, err
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	// A single consumer group is consuming from all the topics. The topic is extracted per message.
+	return ctx, []brokers.Subscription{{Subscription: sub, Ping: ping(cfg, config)}}, nil
+}
+
+// ping verifies the brokers are reachable, and that the topics exist.
+// sarama's client doesn't accept a context, so the check is abandoned (and completes in the background) once the
+// context is done.
+func ping(cfg config, config *sarama.Config) func(context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() {
+			done <- checkTopics(cfg, config)
+		}()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to connect to kafka: %w", ctx.Err())
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// checkTopics connects to the brokers, and verifies the topics exist
+func checkTopics(cfg config, config *sarama.Config) error {
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+	defer client.Close()
+
+	topics, err := client.Topics()
+	if err != nil {
+		return fmt.Errorf("failed to list kafka topics: %w", err)
+	}
+	for _, t := range cfg.Topics {
+		if !slices.Contains(topics, t) {
+			return fmt.Errorf("kafka topic %s not found", t)
+		}
+	}
+	return nil
 }
 
 func parseInitialOffset(value string) (initialOffset int64, err error) {
 	initialOffset = sarama.OffsetNewest // Default
-	if strings.EqualFold(value, "oldest") {
+	if strings.EqualFold(value, "oldest") || strings.EqualFold(value, "earliest") {
 		initialOffset = sarama.OffsetOldest
-	} else if strings.EqualFold(value, "newest") {
+	} else if strings.EqualFold(value, "newest") || strings.EqualFold(value, "latest") {
 		initialOffset = sarama.OffsetNewest
 	} else if value != "" {
 		return 0, fmt.Errorf("kafka error: invalid initialOffset: %s", value)
@@ -142,6 +239,21 @@ func parseInitialOffset(value string) (initialOffset int64, err error) {
 	return initialOffset, err
 }
 
+func parseRebalanceStrategy(value string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(value) {
+	case "", sarama.RangeBalanceStrategyName:
+		return sarama.NewBalanceStrategyRange(), nil
+	case sarama.RoundRobinBalanceStrategyName:
+		return sarama.NewBalanceStrategyRoundRobin(), nil
+	case sarama.StickyBalanceStrategyName:
+		return sarama.NewBalanceStrategySticky(), nil
+	case "cooperative-sticky":
+		return nil, fmt.Errorf("kafka error: cooperative rebalancing is not supported by sarama/kafkapubsub; use `sticky` instead")
+	default:
+		return nil, fmt.Errorf("kafka error: invalid rebalance strategy: %s", value)
+	}
+}
+
 func updateTLSConfig(config *sarama.Config, in config) error {
 	if in.TLSDisable {
 		config.Net.TLS.Enable = false
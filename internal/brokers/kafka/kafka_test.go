@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRebalanceStrategy(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":           sarama.RangeBalanceStrategyName,
+		"range":      sarama.RangeBalanceStrategyName,
+		"roundrobin": sarama.RoundRobinBalanceStrategyName,
+		"Sticky":     sarama.StickyBalanceStrategyName,
+	} {
+		strategy, err := parseRebalanceStrategy(value)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", value, err)
+			continue
+		}
+		if strategy.Name() != expected {
+			t.Errorf("%q: expected strategy %s, got %s", value, expected, strategy.Name())
+		}
+	}
+
+	for _, value := range []string{"cooperative-sticky", "unknown"} {
+		if _, err := parseRebalanceStrategy(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestPingIsBoundedByTheContext(t *testing.T) {
+	// a broker that accepts connections, but never responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = ping(config{Brokers: []string{l.Addr().String()}, Topics: []string{"events"}}, sarama.NewConfig())(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the ping to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the ping to return once the context is done, returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/batcher"
+	"gocloud.dev/pubsub/driver"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	brokers.Register("pulsar", &provider{})
+}
+
+type provider struct{}
+
+func (p *provider) Metadata(_ context.Context, msg *pubsub.Message) brokers.Metadata {
+	var md brokers.Metadata
+	var m pulsar.Message
+	if ok := msg.As(&m); ok {
+		md.Topic = m.Topic()
+		var t configuredTopic
+		if msg.As(&t) {
+			md.Topic = string(t)
+		}
+		md.ID = m.ID().String()
+		md.Key = m.Key()
+		md.Attempts = int(m.RedeliveryCount()) + 1
+		md.Timestamp = m.EventTime()
+		if md.Timestamp.IsZero() {
+			md.Timestamp = m.PublishTime()
+		}
+	}
+	return md
+}
+
+type config struct {
+	URL          string   `mapstructure:"url"`
+	Token        string   `mapstructure:"token"`
+	Topics       []string `mapstructure:"topics"`
+	Subscription string   `mapstructure:"subscription"`
+
+	// SubscriptionType is one of `exclusive` (default), `shared`, `key_shared` or `failover`.
+	// With `key_shared`, the messages of a key are delivered to a single replica, and the message key is available
+	// for the runner's key affinity.
+	SubscriptionType string `mapstructure:"subscription_type"`
+
+	// InitialPosition is where a new subscription starts: `latest` (default) or `earliest`
+	InitialPosition string `mapstructure:"initial_position"`
+
+	// NackRedeliveryDelay is the delay before a nacked message is redelivered (defaults to 1m)
+	NackRedeliveryDelay time.Duration `mapstructure:"nack_redelivery_delay"`
+
+	// MaxOutstanding is shared with the manager's config, and bounds the consumer's receiver queue
+	MaxOutstanding int `mapstructure:"max_outstanding"`
+
+	TLSTrustCertsFile string `mapstructure:"tls_trust_certs_file"`
+	TLSSkipVerify     bool   `mapstructure:"tls_skip_verify"`
+
+	// SubscriptionOptions supports:
+	//   - receiver.queue.size
+	//   - operation.timeout, connection.timeout
+	SubscriptionOptions []string `mapstructure:"subscription_options"`
+}
+
+func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, []brokers.Subscription, error) {
+	cfg := config{}
+	err := c.Unmarshal(&cfg)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	if cfg.URL == "" {
+		return ctx, nil, fmt.Errorf("url required to connect to pulsar")
+	}
+	if len(cfg.Topics) == 0 {
+		return ctx, nil, fmt.Errorf("topics required to connect to pulsar")
+	}
+	if cfg.Subscription == "" {
+		dc := brokers.DataSourceFromContext(ctx)
+		if dc == nil {
+			panic("no DataSource in context")
+		}
+		cfg.Subscription = fmt.Sprintf("%s.%s", dc.Name, dc.Namespace)
+	}
+
+	clientOpts := pulsar.ClientOptions{
+		URL:                        cfg.URL,
+		TLSTrustCertsFilePath:      cfg.TLSTrustCertsFile,
+		TLSAllowInsecureConnection: cfg.TLSSkipVerify,
+	}
+	if cfg.Token != "" {
+		clientOpts.Authentication = pulsar.NewAuthenticationToken(cfg.Token)
+	}
+	consumerOpts := pulsar.ConsumerOptions{
+		Topics:              cfg.Topics,
+		SubscriptionName:    cfg.Subscription,
+		NackRedeliveryDelay: cfg.NackRedeliveryDelay,
+		ReceiverQueueSize:   cfg.MaxOutstanding,
+	}
+	consumerOpts.Type, err = parseSubscriptionType(cfg.SubscriptionType)
+	if err != nil {
+		return ctx, nil, err
+	}
+	consumerOpts.SubscriptionInitialPosition, err = parseInitialPosition(cfg.InitialPosition)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	opts, err := brokers.ParseSubscriptionOptions(cfg.SubscriptionOptions)
+	if err != nil {
+		return ctx, nil, err
+	}
+	err = opts.Apply(ctx, map[string]func(string) error{
+		"receiver.queue.size": brokers.IntOption(&consumerOpts.ReceiverQueueSize),
+		"operation.timeout":   brokers.DurationOption(&clientOpts.OperationTimeout),
+		"connection.timeout":  brokers.DurationOption(&clientOpts.ConnectionTimeout),
+	})
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	client, err := pulsar.NewClient(clientOpts)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to create pulsar client: %w", err)
+	}
+	consumer, err := client.Subscribe(consumerOpts)
+	if err != nil {
+		client.Close()
+		return ctx, nil, fmt.Errorf("failed to subscribe to pulsar: %w", err)
+	}
+
+	ds := &subscription{client: client, consumer: consumer, topics: cfg.Topics}
+	sub := pubsub.NewSubscription(ds, &batcher.Options{MaxHandlers: 1}, nil)
+
+	// A single consumer is consuming from all the topics. The topic is extracted per message.
+	return ctx, []brokers.Subscription{{Subscription: sub, Ping: ping(client, cfg.Topics)}}, nil
+}
+
+// configuredTopic is the topic of a message as it's configured, which the message is attributed to
+type configuredTopic string
+
+// partitionSuffix is the suffix of the partitions' topics of a partitioned topic
+var partitionSuffix = regexp.MustCompile(`-partition-\d+$`)
+
+// fullTopicName returns the fully qualified name of a topic (i.e. `persistent://public/default/topic` of `topic`)
+func fullTopicName(topic string) string {
+	if strings.Contains(topic, "://") {
+		return topic
+	}
+	switch strings.Count(topic, "/") {
+	case 0:
+		return "persistent://public/default/" + topic
+	case 2:
+		return "persistent://" + topic
+	}
+	return topic
+}
+
+// topicName returns the configured topic of a message's topic. Messages of partitioned topics are received from
+// the partition's topic (`<topic>-partition-<n>`), that doesn't match the configured topic.
+func topicName(topic string, configured []string) string {
+	base := partitionSuffix.ReplaceAllString(topic, "")
+	for _, t := range configured {
+		if fullTopicName(t) == fullTopicName(base) {
+			return t
+		}
+	}
+	return base
+}
+
+// ping verifies the topics exist, by looking up their partitions
+func ping(client pulsar.Client, topics []string) func(context.Context) error {
+	return func(context.Context) error {
+		for _, t := range topics {
+			if _, err := client.TopicPartitions(t); err != nil {
+				return fmt.Errorf("failed to lookup pulsar topic %s: %w", t, err)
+			}
+		}
+		return nil
+	}
+}
+
+func parseSubscriptionType(value string) (pulsar.SubscriptionType, error) {
+	switch strings.ReplaceAll(strings.ToLower(value), "-", "_") {
+	case "", "exclusive":
+		return pulsar.Exclusive, nil
+	case "shared":
+		return pulsar.Shared, nil
+	case "key_shared", "keyshared":
+		return pulsar.KeyShared, nil
+	case "failover":
+		return pulsar.Failover, nil
+	}
+	return 0, fmt.Errorf("pulsar error: invalid subscription type: %s", value)
+}
+
+func parseInitialPosition(value string) (pulsar.SubscriptionInitialPosition, error) {
+	switch strings.ToLower(value) {
+	case "", "latest":
+		return pulsar.SubscriptionPositionLatest, nil
+	case "earliest":
+		return pulsar.SubscriptionPositionEarliest, nil
+	}
+	return 0, fmt.Errorf("pulsar error: invalid initial position: %s", value)
+}
+
+// receiveWait is the time to wait for a message before returning an empty batch
+const receiveWait = time.Second
+
+// subscription implements driver.Subscription over a Pulsar consumer
+type subscription struct {
+	client   pulsar.Client
+	consumer pulsar.Consumer
+	topics   []string
+}
+
+func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.Message, error) {
+	var ms []*driver.Message
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(receiveWait):
+		return nil, nil
+	case cm, ok := <-s.consumer.Chan():
+		if !ok {
+			return nil, errors.New("pulsar consumer closed")
+		}
+		ms = append(ms, s.toDriverMessage(cm.Message))
+	}
+
+	for len(ms) < maxMessages {
+		select {
+		case cm, ok := <-s.consumer.Chan():
+			if !ok {
+				return ms, nil
+			}
+			ms = append(ms, s.toDriverMessage(cm.Message))
+		default:
+			return ms, nil
+		}
+	}
+	return ms, nil
+}
+
+func (s *subscription) toDriverMessage(m pulsar.Message) *driver.Message {
+	topic := configuredTopic(topicName(m.Topic(), s.topics))
+	return &driver.Message{
+		LoggableID: m.ID().String(),
+		Body:       m.Payload(),
+		Metadata:   m.Properties(),
+		AckID:      m,
+		AsFunc: func(i any) bool {
+			switch p := i.(type) {
+			case *pulsar.Message:
+				*p = m
+			case *configuredTopic:
+				*p = topic
+			default:
+				return false
+			}
+			return true
+		},
+	}
+}
+
+func (s *subscription) SendAcks(_ context.Context, ackIDs []driver.AckID) error {
+	var errs []error
+	for _, id := range ackIDs {
+		errs = append(errs, s.consumer.Ack(id.(pulsar.Message)))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *subscription) CanNack() bool {
+	return true
+}
+
+// SendNacks redelivers the messages after `NackRedeliveryDelay`.
+func (s *subscription) SendNacks(_ context.Context, ackIDs []driver.AckID) error {
+	for _, id := range ackIDs {
+		s.consumer.Nack(id.(pulsar.Message))
+	}
+	return nil
+}
+
+func (s *subscription) IsRetryable(error) bool {
+	return false
+}
+
+func (s *subscription) As(i any) bool {
+	c, ok := i.(*pulsar.Consumer)
+	if !ok {
+		return false
+	}
+	*c = s.consumer
+	return true
+}
+
+func (s *subscription) ErrorAs(err error, i any) bool {
+	return errors.As(err, i)
+}
+
+func (s *subscription) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return gcerrors.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return gcerrors.DeadlineExceeded
+	}
+
+	var pe *pulsar.Error
+	if errors.As(err, &pe) {
+		switch pe.Result() {
+		case pulsar.TopicNotFound, pulsar.SubscriptionNotFound:
+			return gcerrors.NotFound
+		case pulsar.AuthenticationError, pulsar.AuthorizationError:
+			return gcerrors.PermissionDenied
+		case pulsar.InvalidConfiguration:
+			return gcerrors.InvalidArgument
+		}
+	}
+	return gcerrors.Unknown
+}
+
+func (s *subscription) Close() error {
+	s.consumer.Close()
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,36 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pulsar
+
+import (
+	"testing"
+)
+
+func TestTopicName(t *testing.T) {
+	configured := []string{"orders", "acme/payments/refunds", "persistent://acme/payments/invoices"}
+	for topic, expected := range map[string]string{
+		"persistent://public/default/orders":               "orders",
+		"persistent://public/default/orders-partition-3":   "orders",
+		"persistent://acme/payments/refunds-partition-0":   "acme/payments/refunds",
+		"persistent://acme/payments/invoices-partition-12": "persistent://acme/payments/invoices",
+		"persistent://acme/payments/other-partition-1":     "persistent://acme/payments/other",
+	} {
+		if got := topicName(topic, configured); got != expected {
+			t.Errorf("%s: expected %s, got %s", topic, expected, got)
+		}
+	}
+}
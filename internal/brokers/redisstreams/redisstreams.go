@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redisstreams
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"github.com/redis/go-redis/v9"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/batcher"
+	"gocloud.dev/pubsub/driver"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	brokers.Register("redis-streams", &provider{})
+}
+
+type provider struct{}
+
+func (p *provider) Metadata(_ context.Context, msg *pubsub.Message) brokers.Metadata {
+	var md brokers.Metadata
+	var m *redis.XMessage
+	if ok := msg.As(&m); ok {
+		md.ID = m.ID
+		md.Timestamp = idTimestamp(m.ID)
+	}
+	return md
+}
+
+// idTimestamp extracts the embedded timestamp of a stream entry id (`<millisecondsTime>-<sequenceNumber>`)
+func idTimestamp(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	v, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(v)
+}
+
+// defaultClaimMinIdle is the minimal default of ClaimMinIdle
+const defaultClaimMinIdle = time.Minute
+
+type config struct {
+	Address   string   `mapstructure:"address"`
+	Username  string   `mapstructure:"username"`
+	Password  string   `mapstructure:"password"`
+	DB        int      `mapstructure:"db"`
+	TLS       bool     `mapstructure:"tls"`
+	Streams   []string `mapstructure:"streams"`
+	Group     string   `mapstructure:"group"`
+	Consumer  string   `mapstructure:"consumer"`
+	BodyField string   `mapstructure:"body_field"`
+
+	// StartID is the id the consumer group starts from when it's created (`$` for new entries, `0` for all entries)
+	StartID string `mapstructure:"start_id"`
+
+	// ClaimMinIdle is the time a pending entry (i.e. failed or abandoned by another consumer) is idle before it's
+	// reclaimed and redelivered. An entry is idle from its delivery until it's acked, including the time it waits for a
+	// worker and is being handled (i.e. a slow feature, or while the runtime's circuit breaker is open), so an entry
+	// that is handled for longer is reclaimed and handled twice. Defaults to MaxAckExtension, or a minute if it's
+	// shorter.
+	ClaimMinIdle time.Duration `mapstructure:"claim_min_idle"`
+
+	// MaxOutstanding is shared with the manager's config, and bounds the entries read at once
+	MaxOutstanding int `mapstructure:"max_outstanding"`
+
+	// MaxAckExtension is shared with the manager's config, and is the longest handling the manager protects from
+	// redelivery
+	MaxAckExtension time.Duration `mapstructure:"max_ack_extension"`
+
+	// SubscriptionOptions supports:
+	//   - block: the time to block waiting for new entries (defaults to 1s)
+	SubscriptionOptions []string `mapstructure:"subscription_options"`
+	block               time.Duration
+}
+
+func (p *provider) Subscribe(ctx context.Context, c v1alpha1.ParsedConfig) (context.Context, []brokers.Subscription, error) {
+	cfg := config{}
+	err := c.Unmarshal(&cfg)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	if cfg.Address == "" {
+		return ctx, nil, fmt.Errorf("address required to connect to redis")
+	}
+	if len(cfg.Streams) == 0 {
+		return ctx, nil, fmt.Errorf("streams required to connect to redis")
+	}
+	if cfg.Group == "" {
+		dc := brokers.DataSourceFromContext(ctx)
+		if dc == nil {
+			panic("no DataSource in context")
+		}
+		cfg.Group = fmt.Sprintf("%s.%s", dc.Name, dc.Namespace)
+	}
+	if cfg.Consumer == "" {
+		cfg.Consumer, err = os.Hostname()
+		if err != nil {
+			return ctx, nil, fmt.Errorf("failed to determine the consumer name: %w", err)
+		}
+	}
+	if cfg.BodyField == "" {
+		cfg.BodyField = "body"
+	}
+	if cfg.StartID == "" {
+		cfg.StartID = "$"
+	}
+	if cfg.ClaimMinIdle == 0 {
+		cfg.ClaimMinIdle = max(defaultClaimMinIdle, cfg.MaxAckExtension)
+	}
+
+	cfg.block = time.Second
+	subOpts, err := brokers.ParseSubscriptionOptions(cfg.SubscriptionOptions)
+	if err != nil {
+		return ctx, nil, err
+	}
+	err = subOpts.Apply(ctx, map[string]func(string) error{
+		"block": brokers.DurationOption(&cfg.block),
+	})
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	opts := &redis.Options{
+		Addr:     cfg.Address,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+	go func() {
+		<-ctx.Done()
+		_ = client.Close()
+	}()
+
+	var subs []brokers.Subscription
+	for _, stream := range cfg.Streams {
+		err := client.XGroupCreateMkStream(ctx, stream, cfg.Group, cfg.StartID).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return ctx, nil, fmt.Errorf("failed to create consumer group for %s: %w", stream, err)
+		}
+
+		ds := &subscription{client: client, stream: stream, cfg: cfg}
+		sub := pubsub.NewSubscription(ds, &batcher.Options{MaxHandlers: 1, MaxBatchSize: cfg.MaxOutstanding}, nil)
+		subs = append(subs, brokers.Subscription{Subscription: sub, Topic: stream, Ping: func(ctx context.Context) error {
+			return client.XInfoGroups(ctx, stream).Err()
+		}})
+	}
+	return ctx, subs, nil
+}
+
+// subscription implements driver.Subscription over a Redis Stream consumer group.
+// Nacked entries remain pending, and are reclaimed once they've been idle for `ClaimMinIdle`.
+type subscription struct {
+	client *redis.Client
+	stream string
+	cfg    config
+}
+
+func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.Message, error) {
+	// Reclaim pending entries first, so failures are redelivered before new entries are consumed.
+	entries, _, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.stream,
+		Group:    s.cfg.Group,
+		Consumer: s.cfg.Consumer,
+		MinIdle:  s.cfg.ClaimMinIdle,
+		Start:    "0",
+		Count:    int64(maxMessages),
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.cfg.Group,
+			Consumer: s.cfg.Consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    int64(maxMessages),
+			Block:    s.cfg.block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, st := range streams {
+			entries = append(entries, st.Messages...)
+		}
+	}
+
+	ms := make([]*driver.Message, 0, len(entries))
+	for i := range entries {
+		e := entries[i]
+		m := &driver.Message{
+			LoggableID: e.ID,
+			Metadata:   make(map[string]string),
+			AckID:      e.ID,
+			AsFunc: func(i any) bool {
+				p, ok := i.(**redis.XMessage)
+				if !ok {
+					return false
+				}
+				*p = &e
+				return true
+			},
+		}
+		for k, v := range e.Values {
+			if k == s.cfg.BodyField {
+				m.Body = []byte(fmt.Sprint(v))
+				continue
+			}
+			m.Metadata[k] = fmt.Sprint(v)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func (s *subscription) SendAcks(ctx context.Context, ackIDs []driver.AckID) error {
+	ids := make([]string, 0, len(ackIDs))
+	for _, id := range ackIDs {
+		ids = append(ids, id.(string))
+	}
+	return s.client.XAck(ctx, s.stream, s.cfg.Group, ids...).Err()
+}
+
+func (s *subscription) CanNack() bool {
+	return true
+}
+
+// SendNacks leaves the entries pending, to be reclaimed after `ClaimMinIdle`.
+func (s *subscription) SendNacks(context.Context, []driver.AckID) error {
+	return nil
+}
+
+func (s *subscription) IsRetryable(error) bool {
+	return false
+}
+
+func (s *subscription) As(i any) bool {
+	c, ok := i.(**redis.Client)
+	if !ok {
+		return false
+	}
+	*c = s.client
+	return true
+}
+
+func (s *subscription) ErrorAs(error, any) bool {
+	return false
+}
+
+func (s *subscription) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return gcerrors.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return gcerrors.DeadlineExceeded
+	case strings.HasPrefix(err.Error(), "NOGROUP"):
+		return gcerrors.NotFound
+	case strings.HasPrefix(err.Error(), "NOAUTH"), strings.HasPrefix(err.Error(), "WRONGPASS"):
+		return gcerrors.PermissionDenied
+	}
+	return gcerrors.Unknown
+}
+
+func (s *subscription) Close() error {
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redisstreams
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+// newStream returns an in-memory Redis and its client, and the config of a subscription to its `orders` stream
+func newStream(t *testing.T) (*miniredis.Miniredis, *redis.Client, v1alpha1.ParsedConfig) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = rc.Close()
+	})
+	return mr, rc, v1alpha1.ParsedConfig{
+		"address":              mr.Addr(),
+		"streams":              "orders",
+		"group":                "runner",
+		"consumer":             "replica-1",
+		"start_id":             "0",
+		"subscription_options": "block=50ms",
+	}
+}
+
+func TestReceiveAndAck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rc, cfg := newStream(t)
+	p := &provider{}
+	id, err := rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: "orders",
+		Values: map[string]any{"body": `{"id": "user-1"}`, "traceparent": "00-abc-def-01"},
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, subs, err := p.Subscribe(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].Topic != "orders" {
+		t.Fatalf("expected a subscription of the stream, got %v", subs)
+	}
+	if err := subs[0].Ping(ctx); err != nil {
+		t.Fatalf("expected the ping to succeed, got %s", err)
+	}
+
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	msg, err := subs[0].Receive(rctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Body) != `{"id": "user-1"}` {
+		t.Errorf("expected the body field as the body, got %s", msg.Body)
+	}
+	if msg.Metadata["traceparent"] != "00-abc-def-01" {
+		t.Errorf("expected the other fields as the metadata, got %v", msg.Metadata)
+	}
+	md := p.Metadata(ctx, msg)
+	if md.ID != id {
+		t.Errorf("expected the entry id %s, got %s", id, md.ID)
+	}
+	if md.Timestamp.IsZero() {
+		t.Error("expected the timestamp to be taken from the entry id")
+	}
+
+	msg.Ack()
+	if err := subs[0].Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := rc.XPending(ctx, "orders", "runner").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("expected the acked entry not to be pending, got %d pending", pending.Count)
+	}
+}
+
+func TestNackedEntriesAreReclaimed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rc, cfg := newStream(t)
+	p := &provider{}
+	if err := rc.XAdd(ctx, &redis.XAddArgs{Stream: "orders", Values: map[string]any{"body": "1"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg["claim_min_idle"] = "10ms"
+	ctx, subs, err := p.Subscribe(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	msg, err := subs[0].Receive(rctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Nack()
+
+	redelivered, err := subs[0].Receive(rctx)
+	if err != nil {
+		t.Fatalf("expected the nacked entry to be redelivered, got %s", err)
+	}
+	if msg.LoggableID != redelivered.LoggableID {
+		t.Errorf("expected entry %s to be redelivered, got %s", msg.LoggableID, redelivered.LoggableID)
+	}
+	redelivered.Ack()
+}
+
+func TestEntriesBeingHandledAreNotReclaimed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, rc, cfg := newStream(t)
+	p := &provider{}
+	if err := rc.XAdd(ctx, &redis.XAddArgs{Stream: "orders", Values: map[string]any{"body": "1"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the manager handles the messages for up to 5m, which is longer than the default of a minute
+	cfg["max_ack_extension"] = "5m"
+	ctx, subs, err := p.Subscribe(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	msg, err := subs[0].Receive(rctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// still being handled
+	delivered := time.Now()
+	mr.SetTime(delivered.Add(2 * time.Minute))
+	sctx, scancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer scancel()
+	if redelivered, err := subs[0].Receive(sctx); err == nil {
+		t.Fatalf("expected the entry being handled not to be reclaimed, got %s", redelivered.LoggableID)
+	}
+
+	// abandoned
+	mr.SetTime(delivered.Add(6 * time.Minute))
+	redelivered, err := subs[0].Receive(rctx)
+	if err != nil {
+		t.Fatalf("expected the abandoned entry to be reclaimed, got %s", err)
+	}
+	if msg.LoggableID != redelivered.LoggableID {
+		t.Errorf("expected entry %s to be reclaimed, got %s", msg.LoggableID, redelivered.LoggableID)
+	}
+	redelivered.Ack()
+}
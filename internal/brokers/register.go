@@ -19,4 +19,6 @@ package brokers
 import (
 	_ "github.com/raptor-ml/streaming-runner/internal/brokers/gcppubsub"
 	_ "github.com/raptor-ml/streaming-runner/internal/brokers/kafka"
+	_ "github.com/raptor-ml/streaming-runner/internal/brokers/pulsar"
+	_ "github.com/raptor-ml/streaming-runner/internal/brokers/redisstreams"
 )
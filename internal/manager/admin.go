@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminHandler returns the HTTP handler of the admin endpoints:
+//   - GET /healthz: liveness probe, that also reports the build
+//   - GET /readyz: readiness probe
+//   - POST /pause: stop receiving messages, while keeping the subscription alive
+//   - POST /resume: resume receiving messages. Conflicts while draining, which can't be resumed.
+//   - POST /drain: stop receiving messages, and wait for the in-flight messages to complete
+//
+// The probes are served to anyone that can reach the admin address. The control endpoints (pause, resume and drain)
+// require the token as an `Authorization: Bearer` header, or are served to loopback clients only (i.e. a preStop
+// exec hook) when token is nil.
+func AdminHandler(m Manager, bi BuildInfo, token TokenSource) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "ok (%s)", bi)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !m.Ready(r.Context()) {
+			msg := "not ready"
+			if err := m.Err(); err != nil {
+				msg = fmt.Sprintf("%s: %s", msg, err)
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.Handle("/pause", control(token, func(w http.ResponseWriter, r *http.Request) {
+		m.Pause()
+		_, _ = w.Write([]byte("paused"))
+	}))
+	mux.Handle("/resume", control(token, func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Resume(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		_, _ = w.Write([]byte("resumed"))
+	}))
+	mux.Handle("/drain", control(token, func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Drain(r.Context()); err != nil {
+			http.Error(w, fmt.Sprintf("draining: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("drained"))
+	}))
+	return mux
+}
+
+// control restricts a control endpoint to authorized POST requests
+func control(token TokenSource, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := authorize(token, r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorize verifies the request's bearer token, or that the request is local when there's no token
+func authorize(token TokenSource, r *http.Request) error {
+	if token == nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("unauthorized: no admin token is configured, so only local requests are allowed")
+		}
+		return nil
+	}
+
+	want, err := token()
+	if err != nil {
+		return fmt.Errorf("failed to get admin token: %w", err)
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return fmt.Errorf("unauthorized")
+	}
+	return nil
+}
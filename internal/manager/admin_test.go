@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminControlAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      TokenSource
+		remoteAddr string
+		header     string
+		method     string
+		want       int
+	}{
+		{"local without token", nil, "127.0.0.1:1234", "", http.MethodPost, http.StatusOK},
+		{"local ipv6 without token", nil, "[::1]:1234", "", http.MethodPost, http.StatusOK},
+		{"remote without token", nil, "10.0.0.1:1234", "", http.MethodPost, http.StatusUnauthorized},
+		{"remote with token", StaticToken("secret"), "10.0.0.1:1234", "Bearer secret", http.MethodPost, http.StatusOK},
+		{"wrong token", StaticToken("secret"), "10.0.0.1:1234", "Bearer other", http.MethodPost,
+			http.StatusUnauthorized},
+		{"missing token", StaticToken("secret"), "127.0.0.1:1234", "", http.MethodPost, http.StatusUnauthorized},
+		{"not a post", nil, "127.0.0.1:1234", "", http.MethodGet, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, nil)
+			handler := AdminHandler(h.m, BuildInfo{}, tt.token)
+			req := httptest.NewRequest(tt.method, "/pause", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+			if paused := h.m.Paused(); paused != (tt.want == http.StatusOK) {
+				t.Errorf("expected paused to be %t", !paused)
+			}
+		})
+	}
+
+	// the probes don't require a token
+	h := newHarness(t, nil)
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	AdminHandler(h.m, BuildInfo{}, StaticToken("secret")).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the probes to be served without a token, got %d", rec.Code)
+	}
+}
+
+func TestAdminResumeConflictsWhileDraining(t *testing.T) {
+	h := newHarness(t, nil)
+	handler := AdminHandler(h.m, BuildInfo{}, nil)
+	resume := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/resume", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	h.m.Pause()
+	if code := resume(); code != http.StatusOK {
+		t.Errorf("expected resuming a pause to succeed, got %d", code)
+	}
+	if err := h.m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code := resume(); code != http.StatusConflict {
+		t.Errorf("expected resuming a drain to conflict, got %d", code)
+	}
+	if !h.m.Paused() {
+		t.Error("expected the drain not to be resumed")
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// route dispatches the messages to the workers' queues by their key, so the same key consistently lands on the same
+// worker. Messages without a key are spread round-robin.
+func (m *manager) route(ctx context.Context, msgs <-chan received, queues []chan received, bs BaseStreaming) {
+	next := 0
+	for {
+		var r received
+		select {
+		case <-ctx.Done():
+			return
+		case r = <-msgs:
+		}
+
+		md, ok := m.safeMetadata(ctx, r, bs)
+		if !ok {
+			continue
+		}
+
+		var i int
+		if key := md.Key; key != "" {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(key))
+			i = jumpHash(h.Sum64(), len(queues))
+		} else {
+			i = next % len(queues)
+			next++
+		}
+
+		select {
+		case <-ctx.Done():
+			if r.msg.Nackable() {
+				r.msg.Nack()
+			}
+			return
+		case queues[i] <- r:
+		}
+	}
+}
+
+// jumpHash is Jump Consistent Hash (Lamping & Veach), that maps a key to one of n buckets while moving only 1/n of
+// the keys when the number of buckets changes
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/raptor/api"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource returns the current token, i.e. to authenticate with the runtime
+type TokenSource func() (string, error)
+
+// StaticToken is a TokenSource of a fixed token
+func StaticToken(token string) TokenSource {
+	return func() (string, error) {
+		return token, nil
+	}
+}
+
+// FileToken is a TokenSource that reads the token from a file, and re-reads it when the file is modified.
+// This allows rotating the token (i.e. a mounted Secret) without restarting.
+func FileToken(path string) TokenSource {
+	var mu sync.Mutex
+	var token string
+	var modTime time.Time
+
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat token file: %w", err)
+		}
+		if fi.ModTime().Equal(modTime) && token != "" {
+			return token, nil
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+		modTime = fi.ModTime()
+		return token, nil
+	}
+}
+
+type authRuntime struct {
+	api.RuntimeManager
+	token TokenSource
+}
+
+// WithAuthToken attaches an `authorization` bearer token to the runtime calls.
+// Notice that LoadProgram doesn't accept a context, so the token is attached only to ExecuteProgram calls.
+func WithAuthToken(rm api.RuntimeManager, token TokenSource) api.RuntimeManager {
+	if token == nil {
+		return rm
+	}
+	return &authRuntime{RuntimeManager: rm, token: token}
+}
+
+func (a *authRuntime) ExecuteProgram(ctx context.Context, env string, fqn string, keys api.Keys, row map[string]any, ts time.Time, dryRun bool) (api.Value, api.Keys, error) {
+	token, err := a.token()
+	if err != nil {
+		return api.Value{}, keys, fmt.Errorf("failed to get runtime auth token: %w", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	return a.RuntimeManager.ExecuteProgram(ctx, env, fqn, keys, row, ts, dryRun)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/raptor/api"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/raptor/pkg/protoregistry"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BenchConfig configures a throughput benchmark
+type BenchConfig struct {
+	// Messages is the number of synthetic messages to handle
+	Messages int
+	// Payloads are the bodies of the messages, used round-robin
+	Payloads [][]byte
+	// Topic is the topic the messages are attributed to
+	Topic string
+	// Workers overrides the DataSource's workers, if positive
+	Workers int
+	// MaxConcurrentExec limits the concurrent executions, as `--max-concurrent-exec` does
+	MaxConcurrentExec int
+	// RuntimeLatency is the simulated latency of each execution
+	RuntimeLatency time.Duration
+}
+
+// BenchResult is the outcome of a benchmark
+type BenchResult struct {
+	Messages int
+	Failed   int
+	Duration time.Duration
+	// Latencies are the handling latency percentiles of the messages, by percentile
+	Latencies map[int]time.Duration
+	// Err is the first handling failure, if any
+	Err error
+}
+
+// Throughput is the number of messages handled per second
+func (r BenchResult) Throughput() float64 {
+	if r.Duration == 0 {
+		return 0
+	}
+	return float64(r.Messages) / r.Duration.Seconds()
+}
+
+// Bench handles synthetic messages of an in-memory broker with the DataSource's workers and features, against a
+// no-op runtime, to size the workers and the concurrency before production.
+// The broker of the DataSource isn't connected to, but schemas and descriptor sets are loaded as usual.
+func Bench(ctx context.Context, cfg raptorApi.ParsedConfig, features []*raptorApi.Feature, bc BenchConfig) (BenchResult, error) {
+	if bc.Messages <= 0 || len(bc.Payloads) == 0 {
+		return BenchResult{}, fmt.Errorf("messages and payloads are required")
+	}
+
+	bs, _, err := parseBaseStreaming(cfg)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	if bc.Workers > 0 {
+		bs.Workers = bc.Workers
+	}
+	if bs.Schema != nil && !bs.SkipSchemaRegistration {
+		if _, err := protoregistry.Register(bs.Schema.String()); err != nil {
+			return BenchResult{}, fmt.Errorf("failed to register schema: %w", err)
+		}
+	}
+	bs.descriptor, err = bs.DescriptorSet.load(ctx)
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("failed to load descriptor set: %w", err)
+	}
+	bs.mdExtractor = func(context.Context, *pubsub.Message) brokers.Metadata {
+		return brokers.Metadata{Topic: bc.Topic, Timestamp: time.Now(), ID: newUUID()}
+	}
+
+	m := &manager{
+		logger:         logr.Discard(),
+		runtimeManager: WithConcurrencyLimit(&noopRuntime{latency: bc.RuntimeLatency}, bc.MaxConcurrentExec),
+	}
+	bs.features = newFeatureSet()
+	for _, ftSpec := range features {
+		ft, err := parseFeature(ftSpec, bs)
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("feature %s/%s: %w", ftSpec.Namespace, ftSpec.Name, err)
+		}
+		if ft.Schema != "" && !bs.SkipSchemaRegistration {
+			if _, err := protoregistry.Register(ft.Schema); err != nil {
+				return BenchResult{}, fmt.Errorf("failed to register schema of %s: %w", ft.FQN, err)
+			}
+		}
+		bs.features.Set(client.ObjectKeyFromObject(ftSpec), ft)
+	}
+
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+	for i := 0; i < bc.Messages; i++ {
+		err := topic.Send(ctx, &pubsub.Message{Body: bc.Payloads[i%len(bc.Payloads)]})
+		if err != nil {
+			return BenchResult{}, fmt.Errorf("failed to generate messages: %w", err)
+		}
+	}
+
+	var mu sync.Mutex
+	res := BenchResult{Messages: bc.Messages}
+	latencies := make([]time.Duration, 0, bc.Messages)
+	remaining := make(chan struct{}, bc.Messages)
+	for i := 0; i < bc.Messages; i++ {
+		remaining <- struct{}{}
+	}
+	close(remaining)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < bs.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range remaining {
+				msg, err := sub.Receive(ctx)
+				if err != nil {
+					return
+				}
+				t := time.Now()
+				_, err = m.handle(ctx, msg, bs.mdExtractor(ctx, msg), bs)
+				msg.Ack()
+				d := time.Since(t)
+
+				mu.Lock()
+				latencies = append(latencies, d)
+				if err != nil {
+					res.Failed++
+					if res.Err == nil {
+						res.Err = err
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.Latencies = make(map[int]time.Duration)
+	for _, p := range []int{50, 90, 99} {
+		res.Latencies[p] = latencies[(len(latencies)-1)*p/100]
+	}
+	return res, nil
+}
+
+// noopRuntime is a runtime that executes nothing, after a simulated latency
+type noopRuntime struct {
+	latency time.Duration
+}
+
+func (r *noopRuntime) LoadProgram(string, string, string, []string) (*api.ParsedProgram, error) {
+	return &api.ParsedProgram{}, nil
+}
+
+func (r *noopRuntime) ExecuteProgram(ctx context.Context, _ string, _ string, keys api.Keys, _ map[string]any, ts time.Time, _ bool) (api.Value, api.Keys, error) {
+	if r.latency > 0 {
+		select {
+		case <-ctx.Done():
+			return api.Value{}, nil, ctx.Err()
+		case <-time.After(r.latency):
+		}
+	}
+	return api.Value{Timestamp: ts}, keys, nil
+}
+
+func (r *noopRuntime) GetSidecars() []corev1.Container {
+	return nil
+}
+
+func (r *noopRuntime) GetDefaultEnv() string {
+	return ""
+}
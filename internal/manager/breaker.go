@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/raptor/api"
+	"github.com/sony/gobreaker"
+	"time"
+)
+
+// breakerHoldInterval is the time a worker holds before receiving again while the circuit is open
+const breakerHoldInterval = time.Second
+
+type breakerRuntime struct {
+	api.RuntimeManager
+	cb *gobreaker.CircuitBreaker
+}
+
+// WithCircuitBreaker wraps the RuntimeManager with a circuit breaker.
+// After `threshold` consecutive failures the circuit opens and calls fail fast with gobreaker.ErrOpenState. After
+// `timeout` the circuit becomes half-open, and a single successful probe closes it again.
+// Only failures to reach the runtime are counted, so failures of particular messages or features (i.e. invalid
+// arguments, or a feature's own timeout) don't halt the processing.
+func WithCircuitBreaker(rm api.RuntimeManager, threshold uint32, timeout time.Duration, logger logr.Logger) api.RuntimeManager {
+	if threshold == 0 {
+		return rm
+	}
+
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "runtime",
+		MaxRequests: 1,
+		Timeout:     timeout,
+		IsSuccessful: func(err error) bool {
+			return err == nil || !isUnavailable(err)
+		},
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= threshold
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			logger.Info("runtime circuit breaker state changed", "from", from.String(), "to", to.String())
+			breakerState.Set(float64(to))
+		},
+	})
+	return &breakerRuntime{RuntimeManager: rm, cb: cb}
+}
+
+func (b *breakerRuntime) LoadProgram(env, fqn, program string, packages []string) (*api.ParsedProgram, error) {
+	ret, err := b.cb.Execute(func() (any, error) {
+		return b.RuntimeManager.LoadProgram(env, fqn, program, packages)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.(*api.ParsedProgram), nil
+}
+
+func (b *breakerRuntime) ExecuteProgram(ctx context.Context, env string, fqn string, keys api.Keys, row map[string]any, ts time.Time, dryRun bool) (api.Value, api.Keys, error) {
+	var val api.Value
+	var execErr error
+	_, err := b.cb.Execute(func() (any, error) {
+		val, keys, execErr = b.RuntimeManager.ExecuteProgram(ctx, env, fqn, keys, row, ts, dryRun)
+		// the caller's deadline or cancellation (i.e. the feature's timeout) isn't the runtime's failure
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, execErr
+	})
+	if execErr != nil {
+		return val, keys, execErr
+	}
+	return val, keys, err
+}
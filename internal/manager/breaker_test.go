@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/raptor/api"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	rt := newFakeRuntime()
+	rm := WithCircuitBreaker(rt, 3, 100*time.Millisecond, logr.Discard())
+	execute := func(ctx context.Context) error {
+		_, _, err := rm.ExecuteProgram(ctx, "default", "default.clicks", api.Keys{}, nil, time.Now(), false)
+		return err
+	}
+
+	rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		return status.Error(codes.Unavailable, "connection refused")
+	}
+	for i := 0; i < 3; i++ {
+		_ = execute(context.Background())
+	}
+	if err := execute(context.Background()); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected the circuit to open, got %v", err)
+	}
+
+	rt.execute = nil
+	time.Sleep(150 * time.Millisecond)
+	if err := execute(context.Background()); err != nil {
+		t.Fatalf("expected the probe to succeed, got %s", err)
+	}
+	if err := execute(context.Background()); err != nil {
+		t.Fatalf("expected the circuit to close, got %s", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresMessageFailures(t *testing.T) {
+	rt := newFakeRuntime()
+	rm := WithCircuitBreaker(rt, 3, time.Minute, logr.Discard())
+
+	rt.execute = func(ctx context.Context, _ string, _ api.Keys, row map[string]any) error {
+		if row["slow"] != nil {
+			<-ctx.Done()
+			return status.Error(codes.DeadlineExceeded, "context deadline exceeded")
+		}
+		return status.Error(codes.InvalidArgument, "malformed message")
+	}
+	for i := 0; i < 5; i++ {
+		_, _, err := rm.ExecuteProgram(context.Background(), "default", "default.clicks", api.Keys{}, nil, time.Now(), false)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected the runtime's error, got %v", err)
+		}
+
+		// the feature's own timeout
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, _, err = rm.ExecuteProgram(ctx, "default", "default.clicks", api.Keys{}, map[string]any{"slow": true}, time.Now(), false)
+		cancel()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("expected the timeout, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// BuildInfo describes the running build
+type BuildInfo struct {
+	Version string
+	Commit  string
+}
+
+func (bi BuildInfo) String() string {
+	return fmt.Sprintf("version %s, commit %s", bi.Version, bi.Commit)
+}
+
+// ExportBuildInfo exposes the build info as the `streaming_runner_build_info` metric
+func ExportBuildInfo(bi BuildInfo) {
+	buildInfo.WithLabelValues(bi.Version, bi.Commit, runtime.Version()).Set(1)
+}
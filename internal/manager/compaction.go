@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"time"
+)
+
+// maxCompactionKeys bounds the keys held by the compaction window, which is flushed early when reached
+const maxCompactionKeys = 10000
+
+// compact holds the messages for the compaction window, and forwards only the latest message of each key.
+// Superseded messages are acked without being handled. Messages without a key are forwarded as is.
+func (m *manager) compact(ctx context.Context, msgs <-chan received, bs BaseStreaming) <-chan received {
+	out := make(chan received)
+	go func() {
+		pending := make(map[string]received)
+		var keys []string
+		nackPending := func() {
+			for _, k := range keys {
+				if r := pending[k]; r.msg.Nackable() {
+					r.msg.Nack()
+				}
+			}
+		}
+		flush := func() bool {
+			defer func() {
+				pending = make(map[string]received)
+				keys = nil
+			}()
+			for i, k := range keys {
+				select {
+				case <-ctx.Done():
+					keys = keys[i:]
+					nackPending()
+					return false
+				case out <- pending[k]:
+				}
+			}
+			return true
+		}
+
+		ticker := time.NewTicker(bs.CompactionWindow)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				nackPending()
+				return
+			case <-ticker.C:
+				if !flush() {
+					return
+				}
+			case r := <-msgs:
+				md, ok := m.safeMetadata(ctx, r, bs)
+				if !ok {
+					continue
+				}
+				if md.Key == "" {
+					select {
+					case <-ctx.Done():
+						if r.msg.Nackable() {
+							r.msg.Nack()
+						}
+						nackPending()
+						return
+					case out <- r:
+					}
+					continue
+				}
+
+				// messages of a key are received in order, so the latest received supersedes the previous.
+				// The workers may be shared by several topics, so keys are compacted per topic.
+				topic := md.Topic
+				if topic == "" {
+					topic = r.sub.Topic
+				}
+				k := topic + "\x00" + md.Key
+				if prev, ok := pending[k]; ok {
+					compacted.WithLabelValues(topic).Inc()
+					prev.msg.Ack()
+					prev.inFlight.done()
+				} else {
+					keys = append(keys, k)
+				}
+				pending[k] = r
+				if len(keys) >= maxCompactionKeys && !flush() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
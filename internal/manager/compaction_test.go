@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/pubsub"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCompactionHandlesTheLatestMessagePerKey(t *testing.T) {
+	h := newHarness(t, raptorApi.ParsedConfig{"compaction_window": "200ms"}, "orders", "refunds")
+	h.send("orders", "1", "key", "user-1")
+	h.send("orders", "2", "key", "user-1")
+	h.send("orders", "3", "key", "user-1")
+	h.send("orders", "other", "key", "user-2")
+	// the same key of another topic isn't superseded
+	h.send("refunds", "refund", "key", "user-1")
+
+	// the in-memory broker doesn't preserve the order, so the messages are compacted in the order they were sent
+	msgs := h.receive(5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan received)
+	out := h.m.compact(ctx, in, h.bs)
+	for _, body := range []string{"1", "2", "refund", "other", "3"} {
+		in <- msgs[body]
+	}
+
+	var handled []string
+	timeout := time.After(5 * time.Second)
+	for len(handled) < 3 {
+		select {
+		case r := <-out:
+			handled = append(handled, string(r.msg.Body))
+		case <-timeout:
+			t.Fatalf("expected the compacted messages to be forwarded, got %v", handled)
+		}
+	}
+	select {
+	case r := <-out:
+		t.Fatalf("expected the superseded messages to be dropped, got %s", r.msg.Body)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	sort.Strings(handled)
+	if handled[0] != "3" || handled[1] != "other" || handled[2] != "refund" {
+		t.Errorf("expected the latest message of each topic and key, got %v", handled)
+	}
+}
+
+func TestCompactionRecoversFromMetadataPanics(t *testing.T) {
+	h := newHarness(t, raptorApi.ParsedConfig{"compaction_window": "100ms"}, "orders")
+	h.send("orders", "broken", "key", "user-1")
+	h.send("orders", "valid", "key", "user-2")
+	msgs := h.receive(2)
+
+	extract := h.bs.mdExtractor
+	h.bs.mdExtractor = func(ctx context.Context, msg *pubsub.Message) brokers.Metadata {
+		if string(msg.Body) == "broken" {
+			panic("malformed message")
+		}
+		return extract(ctx, msg)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan received)
+	out := h.m.compact(ctx, in, h.bs)
+	in <- msgs["broken"]
+	in <- msgs["valid"]
+
+	select {
+	case r := <-out:
+		if string(r.msg.Body) != "valid" {
+			t.Errorf("expected the panicking message to be dropped, got %s", r.msg.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the compaction to keep forwarding messages after a panic")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DescriptorSet decodes protobuf messages using a compiled FileDescriptorSet (i.e. `protoc --descriptor_set_out`)
+// instead of a remote schema. `Path` is either a local file or an HTTP(S) URL, and `Message` is the fully qualified
+// name of the message type.
+type DescriptorSet struct {
+	Path    string `mapstructure:"descriptor_set"`
+	Message string `mapstructure:"descriptor_message"`
+}
+
+// descriptorSets caches the parsed descriptor sets by their path, so resubscribing doesn't fetch them again
+var descriptorSets = struct {
+	sync.Mutex
+	files map[string]*protoregistry.Files
+}{files: make(map[string]*protoregistry.Files)}
+
+func (d DescriptorSet) validate() error {
+	if d.Path == "" && d.Message == "" {
+		return nil
+	}
+	if d.Path == "" || d.Message == "" {
+		return fmt.Errorf("descriptor_set and descriptor_message must be provided together")
+	}
+	if !protoreflect.FullName(d.Message).IsValid() {
+		return fmt.Errorf("invalid descriptor message name: %s", d.Message)
+	}
+	return nil
+}
+
+// load returns the descriptor of the message type, or nil if no descriptor set is configured
+func (d DescriptorSet) load(ctx context.Context) (protoreflect.MessageDescriptor, error) {
+	if d.Path == "" {
+		return nil, nil
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+
+	files, err := d.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(d.Message))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message %s in the descriptor set: %w", d.Message, err)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", d.Message)
+	}
+	return md, nil
+}
+
+func (d DescriptorSet) files(ctx context.Context) (*protoregistry.Files, error) {
+	descriptorSets.Lock()
+	defer descriptorSets.Unlock()
+	if files, ok := descriptorSets.files[d.Path]; ok {
+		return files, nil
+	}
+
+	b, err := readDescriptorSet(ctx, d.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build descriptors: %w", err)
+	}
+	descriptorSets.files[d.Path] = files
+	return files, nil
+}
+
+func readDescriptorSet(ctx context.Context, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return os.ReadFile(path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is the interval to check whether the in-flight messages completed while draining
+const drainPollInterval = 100 * time.Millisecond
+
+// inFlight counts the messages received from a subscription that weren't processed yet
+type inFlight struct {
+	n atomic.Int64
+}
+
+func (f *inFlight) add() {
+	if f != nil {
+		f.n.Add(1)
+	}
+}
+
+func (f *inFlight) done() {
+	if f != nil {
+		f.n.Add(-1)
+	}
+}
+
+func (f *inFlight) idle() bool {
+	return f == nil || f.n.Load() <= 0
+}
+
+// Drain stops receiving messages, and waits for the in-flight messages to complete (i.e. before terminating the
+// replica during a blue/green deployment). Unlike Pause, draining can't be resumed, and the manager is not ready
+// until the process is restarted. Draining again only waits for the in-flight messages.
+func (m *manager) Drain(ctx context.Context) error {
+	if !m.draining.Swap(true) {
+		m.logger.Info("Draining...")
+	}
+	m.pauser.Pause()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		m.mu.RLock()
+		bs := m.bs
+		m.mu.RUnlock()
+		if bs == nil || bs.inFlight.idle() {
+			m.logger.Info("Drained")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(errors.New("messages are still in-flight"), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ErrDraining is returned when resuming a draining manager, which can't be resumed
+var ErrDraining = errors.New("draining can't be resumed")
+
+// Resume resumes receiving messages, unless the manager is draining
+func (m *manager) Resume() error {
+	if m.draining.Load() {
+		return ErrDraining
+	}
+	m.pauser.Resume()
+	return nil
+}
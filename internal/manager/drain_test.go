@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/raptor-ml/raptor/api"
+	"testing"
+	"time"
+)
+
+func TestDrainCompletesInFlightMessages(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	ft := h.addFeature(testFeature("clicks", ""))
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h.rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	h.start()
+	h.send("events", `{"id": "user-1"}`, "id", "msg-1")
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- h.m.Drain(context.Background())
+	}()
+	h.eventually(func() bool { return !h.m.Ready(context.Background()) }, "expected the manager not to be ready")
+	select {
+	case err := <-drained:
+		t.Fatalf("expected draining to wait for the in-flight message, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	h.send("events", `{"id": "user-2"}`, "id", "msg-2")
+	close(release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected draining to complete once the in-flight message completed")
+	}
+
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "a message was received after draining")
+	if err := h.m.Resume(); !errors.Is(err, ErrDraining) {
+		t.Errorf("expected resuming a drain to fail, got %v", err)
+	}
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "a message was received after resuming a drain")
+	if h.m.Ready(context.Background()) {
+		t.Error("expected the drained manager not to be ready")
+	}
+}
+
+func TestDrainWithoutMessages(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	h.addFeature(testFeature("clicks", ""))
+	h.start()
+	// the receivers are blocked on the empty topic
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.m.Drain(ctx); err != nil {
+		t.Fatalf("expected the blocked receivers not to hold the drain, got %s", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"net/url"
+	"time"
+)
+
+// Dump logs the current state of the manager, for debugging environments where the admin endpoint isn't reachable
+func (m *manager) Dump() {
+	m.mu.RLock()
+	bs, ready, err := m.bs, m.ready, m.err
+	m.mu.RUnlock()
+
+	lastReceive := make(map[string]time.Time)
+	m.lastReceive.Range(func(topic, t any) bool {
+		lastReceive[topic.(string)] = t.(time.Time)
+		return true
+	})
+
+	if bs == nil {
+		m.logger.Info("state dump", "ready", ready, "paused", m.Paused(), "draining", m.draining.Load(), "error", err, "lastReceive", lastReceive)
+		return
+	}
+
+	cfg := *bs
+	if u, err := url.Parse(cfg.Enrichment.URL); err == nil {
+		cfg.Enrichment.URL = u.Redacted()
+	}
+	if u, err := url.Parse(cfg.Webhook.URL); err == nil {
+		cfg.Webhook.URL = u.Redacted()
+	}
+	if cfg.Webhook.Secret != "" {
+		cfg.Webhook.Secret = "xxxxx"
+	}
+	features := make(map[string]string)
+	var disabled []string
+	for _, ft := range bs.features.List() {
+		features[ft.FQN] = ft.sha1
+		if ft.health.disabled() {
+			disabled = append(disabled, ft.FQN)
+		}
+	}
+	m.logger.Info("state dump", "ready", ready, "paused", m.Paused(), "draining", m.draining.Load(), "error", err, "config", cfg,
+		"workers", bs.Workers, "topicWorkers", bs.topicWorkers, "features", features, "disabledFeatures", disabled,
+		"lastReceive", lastReceive)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EnrichPolicyFail fails the message when the enrichment lookup fails
+	EnrichPolicyFail = "fail"
+	// EnrichPolicyProceed handles the message without the enrichment when the enrichment lookup fails
+	EnrichPolicyProceed = "proceed"
+)
+
+// maxEnrichCacheSize bounds the memory used for caching the lookups
+const maxEnrichCacheSize = 10_000
+
+// Enrichment joins reference data onto the message before it's being executed.
+// The value of the `Key` field in the message is looked up using the `URL`, and injected into the message as
+// `Field`. The `URL` is either an HTTP(S) endpoint, where `{key}` is replaced with the key, or a Redis URL, where the
+// looked-up Redis key is `RedisKey` (defaults to `{key}`).
+type Enrichment struct {
+	URL      string        `mapstructure:"enrich_url"`
+	Key      string        `mapstructure:"enrich_key"`
+	Field    string        `mapstructure:"enrich_field"`
+	RedisKey string        `mapstructure:"enrich_redis_key"`
+	TTL      time.Duration `mapstructure:"enrich_ttl"`
+	Policy   string        `mapstructure:"enrich_policy"`
+}
+
+type lookupFn func(ctx context.Context, key string) ([]byte, error)
+
+type cachedLookup struct {
+	value   any
+	expires time.Time
+}
+
+type enricher struct {
+	Enrichment
+	lookup lookupFn
+	logger logr.Logger
+
+	mu    sync.Mutex
+	cache map[string]cachedLookup
+}
+
+func (e Enrichment) validate() error {
+	if e.URL == "" {
+		return nil
+	}
+	if e.Key == "" {
+		return fmt.Errorf("enrich_key is required for enrichment")
+	}
+	switch e.Policy {
+	case "", EnrichPolicyFail, EnrichPolicyProceed:
+	default:
+		return fmt.Errorf("invalid enrichment policy: %s", e.Policy)
+	}
+
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("invalid enrichment url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "redis", "rediss":
+	default:
+		return fmt.Errorf("unsupported enrichment url scheme: %s", u.Scheme)
+	}
+	return nil
+}
+
+func newEnricher(ctx context.Context, e Enrichment, logger logr.Logger) (*enricher, error) {
+	if e.URL == "" {
+		return nil, nil
+	}
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	if e.Field == "" {
+		e.Field = "enrichment"
+	}
+	if e.TTL == 0 {
+		e.TTL = time.Minute
+	}
+	if e.Policy == "" {
+		e.Policy = EnrichPolicyFail
+	}
+
+	en := &enricher{Enrichment: e, logger: logger, cache: make(map[string]cachedLookup)}
+	switch {
+	case strings.HasPrefix(e.URL, "http"):
+		en.lookup = httpLookup(e.URL)
+	default:
+		opts, err := redis.ParseURL(e.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enrichment redis url: %w", err)
+		}
+		client := redis.NewClient(opts)
+		go func() {
+			<-ctx.Done()
+			_ = client.Close()
+		}()
+		if e.RedisKey == "" {
+			e.RedisKey = "{key}"
+		}
+		en.lookup = func(ctx context.Context, key string) ([]byte, error) {
+			return client.Get(ctx, strings.ReplaceAll(e.RedisKey, "{key}", key)).Bytes()
+		}
+	}
+	return en, nil
+}
+
+func httpLookup(tpl string) lookupFn {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(ctx context.Context, key string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(tpl, "{key}", url.PathEscape(key)), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("enrichment lookup returned %s", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+}
+
+// enrich injects the looked-up value into the row, according to the enrichment policy
+func (e *enricher) enrich(ctx context.Context, row map[string]any) error {
+	err := e.do(ctx, row)
+	if err != nil && e.Policy == EnrichPolicyProceed {
+		logger := e.logger
+		if l, err := logr.FromContext(ctx); err == nil {
+			logger = l
+		}
+		logger.V(1).Info("proceeding without enrichment", "error", err.Error())
+		return nil
+	}
+	return err
+}
+
+func (e *enricher) do(ctx context.Context, row map[string]any) error {
+	k, ok := row[e.Key]
+	if !ok {
+		return fmt.Errorf("enrichment key %s is missing in the message", e.Key)
+	}
+	key := fmt.Sprintf("%v", k)
+
+	e.mu.Lock()
+	c, ok := e.cache[key]
+	e.mu.Unlock()
+
+	if !ok || time.Now().After(c.expires) {
+		// a missing Redis key is cached as a nil value
+		var v any
+		b, err := e.lookup(ctx, key)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, &v); err != nil {
+				v = string(b)
+			}
+		case !errors.Is(err, redis.Nil):
+			return fmt.Errorf("failed to lookup enrichment for %s: %w", key, err)
+		}
+		c = cachedLookup{value: v, expires: time.Now().Add(e.TTL)}
+
+		e.mu.Lock()
+		if len(e.cache) >= maxEnrichCacheSize {
+			e.cache = make(map[string]cachedLookup)
+		}
+		e.cache[key] = c
+		e.mu.Unlock()
+	}
+
+	for k, v := range flattenMap(map[string]any{e.Field: c.value}) {
+		row[k] = v
+	}
+	return nil
+}
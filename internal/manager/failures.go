@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/pubsub"
+	"time"
+)
+
+// failureBuffer is the number of failure records waiting to be published, before new records are dropped
+const failureBuffer = 1000
+
+// failureRecord describes a message that failed to be handled
+type failureRecord struct {
+	Topic     string    `json:"topic"`
+	ID        string    `json:"id"`
+	Key       string    `json:"key,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	FQN       string    `json:"fqn,omitempty"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// failurePublisher publishes failure records to a topic in the background, so it never blocks handling messages
+type failurePublisher struct {
+	topic   *pubsub.Topic
+	records chan failureRecord
+	logger  logr.Logger
+}
+
+// newFailurePublisher opens the topic by its url (i.e. `gcppubsub://projects/myproject/topics/failures`).
+// The topic is closed once the context is done.
+func newFailurePublisher(ctx context.Context, url string, logger logr.Logger) (*failurePublisher, error) {
+	if url == "" {
+		return nil, nil
+	}
+	topic, err := pubsub.OpenTopic(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failure topic: %w", err)
+	}
+
+	p := &failurePublisher{topic: topic, records: make(chan failureRecord, failureBuffer), logger: logger}
+	go p.run(ctx)
+	return p, nil
+}
+
+func (p *failurePublisher) run(ctx context.Context) {
+	defer func() {
+		if err := p.topic.Shutdown(context.TODO()); err != nil {
+			p.logger.Error(err, "failed to shutdown failure topic")
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-p.records:
+			body, err := json.Marshal(r)
+			if err != nil {
+				p.logger.Error(err, "failed to marshal failure record")
+				continue
+			}
+			if err := p.topic.Send(ctx, &pubsub.Message{Body: body}); err != nil && ctx.Err() == nil {
+				p.logger.Error(err, "failed to publish failure record", "id", r.ID)
+			}
+		}
+	}
+}
+
+// publish queues a failure record of the message. Records are dropped when the queue is full.
+func (p *failurePublisher) publish(md brokers.Metadata, err error) {
+	r := failureRecord{
+		Topic:     md.Topic,
+		ID:        md.ID,
+		Key:       md.Key,
+		Timestamp: md.Timestamp,
+		Error:     err.Error(),
+		FailedAt:  time.Now(),
+	}
+	var fe *featureError
+	if errors.As(err, &fe) {
+		r.FQN = fe.FQN
+	}
+
+	select {
+	case p.records <- r:
+	default:
+		failureRecordsDropped.Inc()
+	}
+}
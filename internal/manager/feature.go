@@ -19,29 +19,114 @@ package manager
 import (
 	"context"
 	"encoding/json"
+	stdErrors "errors"
 	"fmt"
+	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/raptor-ml/raptor/api"
 	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
 	"github.com/raptor-ml/raptor/pkg/protoregistry"
 	"github.com/raptor-ml/streaming-runner/pkg/brokers"
 	"gocloud.dev/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/dynamicpb"
+	"hash/fnv"
+	"k8s.io/apimachinery/pkg/labels"
+	"math"
+	"math/rand"
 	"net/url"
+	"runtime/debug"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Feature struct {
 	Schema   string   `json:"schema,omitempty"`
 	Packages []string `json:"packages,omitempty"`
+
+	// Topics limits the feature to messages of these topics. Defaults to all topics.
+	Topics []string `json:"topics,omitempty"`
+
+	// SampleRate is the fraction (0.0-1.0) of the messages this feature is computed on. Defaults to all messages.
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+
+	// EntitySampleRate is the fraction (0.0-1.0) of the entities this feature is computed for. Unlike SampleRate,
+	// all the messages of a sampled entity are computed, so an entity is consistently in or out. Defaults to all
+	// entities.
+	EntitySampleRate *float64 `json:"entity_sample_rate,omitempty"`
+
+	// ProgramURL loads the program from an HTTP(S) URL, or from a ConfigMap key in the feature's namespace
+	// (`configmap://name/key`), instead of the inline code. Programs loaded from a ConfigMap are reloaded when it
+	// changes.
+	ProgramURL string `json:"program_url,omitempty"`
+
+	// Timeout limits the execution of the feature's program (i.e. `500ms`). Defaults to no limit.
+	Timeout string `json:"timeout,omitempty"`
+
 	*api.FeatureDescriptor
+
+	timeout time.Duration
+
+	// sha1 is the checksum of the feature's program
+	sha1       string
+	ref        raptorApi.ResourceReference
+	generation int64
+	health     *featureHealth
 }
 
-// if a particular feature extraction has failed, it should log it and allow other to live in peace
-func (m *manager) getFeatureDefinitions(ctx context.Context, in *raptorApi.DataSource, bsc BaseStreaming) []*Feature {
-	var features []*Feature
+// sampled reports whether the feature should be computed for the message.
+// The decision is deterministic by the message id, so the same message is consistently sampled across features
+// and redeliveries. Messages without an id are sampled randomly.
+func (ft *Feature) sampled(md brokers.Metadata) bool {
+	if ft.SampleRate == nil || *ft.SampleRate >= 1 {
+		return true
+	}
+	if md.ID == "" {
+		return rand.Float64() < *ft.SampleRate
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(md.ID))
+	return float64(h.Sum64())/math.MaxUint64 < *ft.SampleRate
+}
+
+// entitySampled reports whether the feature should be computed for the entity.
+// The decision is deterministic by the entity's keys, so an entity is consistently sampled across messages.
+func (ft *Feature) entitySampled(keys api.Keys) bool {
+	if ft.EntitySampleRate == nil || *ft.EntitySampleRate >= 1 {
+		return true
+	}
+
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	h := fnv.New64a()
+	for _, k := range names {
+		_, _ = h.Write([]byte(k))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(keys[k]))
+		_, _ = h.Write([]byte{0})
+	}
+	return float64(h.Sum64())/math.MaxUint64 < *ft.EntitySampleRate
+}
+
+// if a particular feature extraction has failed, it should log it and allow other to live in peace.
+// Features that failed since the runtime is unreachable are returned as pending, to be retried.
+func (m *manager) getFeatureDefinitions(ctx context.Context, in *raptorApi.DataSource, bsc BaseStreaming) (*featureSet, []raptorApi.ResourceReference) {
+	features := newFeatureSet()
+	var pending []raptorApi.ResourceReference
+	var selected []string
 	m.logger.Info("fetching feature definitions...")
 	for _, ref := range in.Status.Features {
 		m.logger.V(1).Info(fmt.Sprintf("fetching feature definition: %s", ref.Name))
@@ -51,22 +136,184 @@ func (m *manager) getFeatureDefinitions(ctx context.Context, in *raptorApi.DataS
 			ref.Namespace = in.Namespace
 		}
 		ft, err := m.getFeature(ctx, ref, bsc)
+		if errors.Is(err, errNotSelected) {
+			m.logger.V(1).Info(fmt.Sprintf("feature %s is not selected", ref.Name))
+			continue
+		}
+		if isUnavailable(err) {
+			m.logger.Error(err, "runtime is unavailable, the feature is pending", "feature", ref.Name)
+			pending = append(pending, ref)
+			continue
+		}
 		if err != nil {
 			m.logger.Error(err, "failed to fetch feature")
+			continue
 		}
-		features = append(features, ft)
+		features.Set(ref.ObjectKey(), ft)
+		selected = append(selected, ft.FQN)
+	}
+	m.logger.Info("features selected", "features", selected)
+	return features, pending
+}
+
+// featureRef returns the DataSource's reference to the feature, if the feature belongs to it
+func featureRef(in *raptorApi.DataSource, ftSpec *raptorApi.Feature) (raptorApi.ResourceReference, bool) {
+	key := client.ObjectKeyFromObject(ftSpec)
+	for _, ref := range in.Status.Features {
+		if ref.Namespace == "" {
+			ref.Namespace = in.Namespace
+		}
+		if ref.ObjectKey() == key {
+			return ref, true
+		}
+	}
+	return raptorApi.ResourceReference{}, false
+}
+
+// reloadFeature (re)loads a feature of the running DataSource, without resubscribing.
+// Unless changed is set, features that are already loaded are left as is.
+// If the new definition fails to load, the previous one is kept.
+func (m *manager) reloadFeature(ctx context.Context, ftSpec *raptorApi.Feature, changed bool) {
+	m.mu.RLock()
+	in, bs := m.in, m.bs
+	m.mu.RUnlock()
+	if in == nil || bs == nil || bs.features == nil {
+		return
+	}
+
+	ref, ok := featureRef(in, ftSpec)
+	if !ok || (!changed && bs.features.Has(ref.ObjectKey())) {
+		return
+	}
+
+	logger := m.logger.WithValues("feature", ref.Name)
+	ft, err := m.getFeature(ctx, ref, *bs)
+	if errors.Is(err, errNotSelected) {
+		if bs.features.Has(ref.ObjectKey()) {
+			bs.features.Delete(ref.ObjectKey())
+			logger.Info("feature is no longer selected")
+		}
+		return
+	}
+	if err != nil {
+		logger.Error(err, "failed to reload feature; keeping the previous definition")
+		return
+	}
+	bs.features.Set(ref.ObjectKey(), ft)
+	logger.Info("feature reloaded", "generation", ftSpec.Generation)
+}
+
+// syncFeatures loads the features added to the DataSource's status, and stops handling the removed ones
+func (m *manager) syncFeatures(ctx context.Context, in *raptorApi.DataSource) {
+	m.mu.RLock()
+	bs := m.bs
+	m.mu.RUnlock()
+	if bs == nil || bs.features == nil {
+		return
+	}
+
+	refs := make(map[client.ObjectKey]struct{})
+	for _, ref := range in.Status.Features {
+		if ref.Namespace == "" {
+			ref.Namespace = in.Namespace
+		}
+		refs[ref.ObjectKey()] = struct{}{}
+		if bs.features.Has(ref.ObjectKey()) {
+			continue
+		}
+
+		ft, err := m.getFeature(ctx, ref, *bs)
+		if errors.Is(err, errNotSelected) {
+			continue
+		}
+		if err != nil {
+			m.logger.Error(err, "failed to fetch feature", "feature", ref.Name)
+			continue
+		}
+		bs.features.Set(ref.ObjectKey(), ft)
+		m.logger.Info("feature added", "feature", ref.Name)
+	}
+	for _, ft := range bs.features.List() {
+		if _, ok := refs[ft.ref.ObjectKey()]; !ok {
+			bs.features.Delete(ft.ref.ObjectKey())
+			m.logger.Info("feature removed", "feature", ft.ref.Name)
+		}
+	}
+}
+
+// removeFeature stops handling a deleted feature
+func (m *manager) removeFeature(ftSpec *raptorApi.Feature) {
+	m.mu.RLock()
+	bs := m.bs
+	m.mu.RUnlock()
+	if bs == nil || bs.features == nil {
+		return
+	}
+
+	key := client.ObjectKeyFromObject(ftSpec)
+	if bs.features.Has(key) {
+		bs.features.Delete(key)
+		m.logger.Info("feature removed", "feature", key.Name)
+	}
+}
+
+// errNotSelected is returned for features that don't match the feature selectors
+var errNotSelected = errors.New("feature is not selected")
+
+// selected reports whether the feature matches both the runner's and the DataSource's feature selectors
+func (m *manager) selected(ftSpec *raptorApi.Feature, bs BaseStreaming) bool {
+	set := labels.Set(ftSpec.Labels)
+	if m.featureSelector != nil && !m.featureSelector.Matches(set) {
+		return false
 	}
-	return features
+	return bs.featureSelector == nil || bs.featureSelector.Matches(set)
 }
+
 func (m *manager) getFeature(ctx context.Context, ref raptorApi.ResourceReference, bs BaseStreaming) (*Feature, error) {
 	ftSpec := raptorApi.Feature{}
 	err := m.client.Get(ctx, ref.ObjectKey(), &ftSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch feature definition: %w", err)
 	}
+	if !m.selected(&ftSpec, bs) {
+		return nil, errNotSelected
+	}
+
+	ft, err := parseFeature(&ftSpec, bs)
+	if err != nil {
+		return nil, err
+	}
+
+	if ft.Schema != "" && !bs.SkipSchemaRegistration {
+		u, _ := url.Parse(ft.Schema)
+		if !(bs.Schema != nil && u.Scheme == bs.Schema.Scheme && u.Host == bs.Schema.Host) {
+			_, err := registerSchema(m.logger, ft.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("failed to register schema: %w", err)
+			}
+		}
+	}
+
+	program, err := m.program(ctx, ft, ftSpec.Spec.Builder.Code)
+	if err != nil {
+		return nil, err
+	}
+	ft.sha1 = programChecksum(program)
 
-	ft := &Feature{}
-	err = json.Unmarshal(ftSpec.Spec.Builder.Raw, ft)
+	start := time.Now()
+	_, err = m.runtimeManager.LoadProgram(ft.RuntimeEnv, ft.FQN, program, ft.Packages)
+	observeRegistration(m.logger, registrationProgram, ft.FQN, start)
+	if status.Code(err) == codes.ResourceExhausted {
+		return nil, fmt.Errorf("the program of %s is too large for the runtime (%d bytes); "+
+			"consider splitting it or moving shared code to a package: %w", ft.FQN, len(program), err)
+	}
+	return ft, err
+}
+
+// parseFeature parses and validates the feature definition, without connecting to anything
+func parseFeature(ftSpec *raptorApi.Feature, bs BaseStreaming) (*Feature, error) {
+	ft := &Feature{health: &featureHealth{}}
+	err := json.Unmarshal(ftSpec.Spec.Builder.Raw, ft)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal feature definition: %w", err)
 	}
@@ -76,103 +323,320 @@ func (m *manager) getFeature(ctx context.Context, ref raptorApi.ResourceReferenc
 	}
 
 	ft.Packages = ftSpec.Spec.Builder.Packages
+	ft.sha1 = programChecksum(ftSpec.Spec.Builder.Code)
+	ft.ref = raptorApi.ResourceReference{Name: ftSpec.Name, Namespace: ftSpec.Namespace}
+	ft.generation = ftSpec.Generation
+
+	if ft.ProgramURL != "" {
+		u, err := url.Parse(ft.ProgramURL)
+		if err != nil || !validProgramURL(u) {
+			return nil, fmt.Errorf("invalid program url: %s", ft.ProgramURL)
+		}
+	}
+
+	if ft.SampleRate != nil && (*ft.SampleRate < 0 || *ft.SampleRate > 1) {
+		return nil, fmt.Errorf("invalid sample rate %f: must be between 0.0 and 1.0", *ft.SampleRate)
+	}
+	if ft.EntitySampleRate != nil && (*ft.EntitySampleRate < 0 || *ft.EntitySampleRate > 1) {
+		return nil, fmt.Errorf("invalid entity sample rate %f: must be between 0.0 and 1.0", *ft.EntitySampleRate)
+	}
+
+	if ft.Timeout != "" {
+		ft.timeout, err = time.ParseDuration(ft.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		if ft.timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %s: must be positive", ft.Timeout)
+		}
+	}
 
 	if ft.Schema == "" && bs.Schema != nil {
 		ft.Schema = bs.Schema.String()
 	}
 	if ft.Schema != "" {
 		u, err := url.Parse(ft.Schema)
-		if err == nil && u.Scheme != "" && u.Host != "" && u.Fragment != "" {
-			if !(bs.Schema != nil && u.Scheme == bs.Schema.Scheme && u.Host == bs.Schema.Host) {
-				_, err := protoregistry.Register(ft.Schema)
-				if err != nil {
-					return nil, fmt.Errorf("failed to register schema: %w", err)
-				}
-			}
-		} else {
+		if err != nil || !validSchemaURL(u) {
 			return nil, fmt.Errorf("invalid schema provided (did you mentioned the message type?)")
 		}
 	}
 
-	ft.FeatureDescriptor, err = api.FeatureDescriptorFromManifest(&ftSpec)
+	ft.FeatureDescriptor, err = api.FeatureDescriptorFromManifest(ftSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create feature descriptor: %w", err)
 	}
+	return ft, nil
+}
 
-	_, err = m.runtimeManager.LoadProgram(ft.RuntimeEnv, ft.FQN, ftSpec.Spec.Builder.Code, ft.Packages)
-	return ft, err
+// validSchemaURL checks the schema url is a remote schema with a message type (i.e. `https://host/file.proto#Msg`)
+func validSchemaURL(u *url.URL) bool {
+	return u.Scheme != "" && u.Host != "" && u.Fragment != ""
 }
 
-func (m *manager) handle(ctx context.Context, msg *pubsub.Message, md brokers.Metadata, bs BaseStreaming) error {
-	for _, ft := range bs.features {
+// handle computes the features of the message, and returns the fqns of the features it was handled by
+func (m *manager) handle(ctx context.Context, msg *pubsub.Message, md brokers.Metadata, bs BaseStreaming) ([]string, error) {
+	if !bs.runtimeGate.open() {
+		return nil, ErrRuntimeUnavailable
+	}
 
-		var jsonMsg []byte
-		var row map[string]any
-		if ft.Schema != "" {
-			u, err := url.Parse(ft.Schema)
-			if err != nil {
-				return fmt.Errorf("failed to parse data schema: %w", err)
-			}
+	messageBytes.WithLabelValues(md.Topic).Observe(float64(len(msg.Body)))
+	// the age of messages without a timestamp is unknown
+	if !md.Timestamp.IsZero() && !md.TimestampFallback {
+		age := time.Since(md.Timestamp)
+		if age < 0 {
+			age = 0
+		}
+		messageAge.WithLabelValues(md.Topic).Observe(age.Seconds())
+		if bs.AgeWarningThreshold > 0 && age > bs.AgeWarningThreshold {
+			logr.FromContextOrDiscard(ctx).Info("message is older than the age warning threshold", "age", age)
+		}
+	}
 
-			md, err := protoregistry.GetDescriptor(u.Fragment)
-			if err != nil {
-				if !errors.Is(err, protoregistry.ErrNotFound) {
-					return fmt.Errorf("failed to find proto type for message")
-				}
+	if len(msg.Body) == 0 {
+		kind := "empty"
+		if msg.Body == nil {
+			kind = "null"
+		}
+		tombstones.WithLabelValues(md.Topic, kind).Inc()
+		if bs.TombstonePolicy != TombstonePass {
+			return nil, nil
+		}
+	}
 
-				pack, err := protoregistry.Register(ft.Schema)
-				if err != nil && !errors.Is(err, protoregistry.ErrAlreadyRegistered) {
-					return fmt.Errorf("failed to register proto type: %w", err)
-				}
+	ctx = withTraceContext(ctx, msg.Metadata)
+	ctx = withForwardedHeaders(ctx, msg.Metadata, bs.forwardHeaders)
+	if len(bs.dsMetadata) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, bs.dsMetadata...)
+	}
 
-				s := u.Fragment
-				if strings.Count(s, ".") < 1 {
-					s = fmt.Sprintf("%s.%s", pack, u.Fragment)
-				}
-				md, err = protoregistry.GetDescriptor(s)
-				if err != nil {
-					panic(fmt.Errorf("failed to get a schema that was just registered: %w", err))
+	body := msg.Body
+	if len(body) > 0 {
+		var err error
+		encoding := contentEncoding(msg.Metadata)
+		body, err = decompress(body, encoding, bs.MaxDecompressedSize)
+		if err != nil {
+			return nil, &encodingError{fmt.Errorf("failed to decompress message: %w", err)}
+		}
+		if encoding != "" && encoding != "identity" {
+			decompressedMessageBytes.WithLabelValues(md.Topic).Observe(float64(len(body)))
+		}
+
+		body, err = bs.transforms.Apply(ctx, body, &md)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform message: %w", err)
+		}
+	}
+
+	var features []*Feature
+	var fqns []string
+	for _, ft := range bs.features.List() {
+		if len(ft.Topics) > 0 && !slices.Contains(ft.Topics, md.Topic) {
+			continue
+		}
+		if !ft.sampled(md) {
+			sampledOut.WithLabelValues(featureLabels.label(ft.FQN)).Inc()
+			continue
+		}
+		if ft.health.disabled() {
+			disabledSkips.WithLabelValues(featureLabels.label(ft.FQN)).Inc()
+			continue
+		}
+		features = append(features, ft)
+		fqns = append(fqns, ft.FQN)
+	}
+
+	if bs.ParallelFeatures && len(features) > 1 {
+		return fqns, m.handleParallel(ctx, features, body, md, bs)
+	}
+	for i, ft := range features {
+		if err := m.handleFeature(ctx, ft, body, md, bs); err != nil {
+			return fqns[:i+1], &featureError{FQN: ft.FQN, err: err}
+		}
+	}
+	return fqns, nil
+}
+
+// handleParallel computes the features of the message concurrently. Unlike the sequential handling, a failing
+// feature doesn't prevent the others from being computed; the message fails if any of them failed.
+func (m *manager) handleParallel(ctx context.Context, features []*Feature, body []byte, md brokers.Metadata, bs BaseStreaming) error {
+	errs := make([]error, len(features))
+	var wg sync.WaitGroup
+	for i, ft := range features {
+		wg.Add(1)
+		go func(i int, ft *Feature) {
+			defer wg.Done()
+			// the worker can't recover from panics of other goroutines
+			defer func() {
+				if p := recover(); p != nil {
+					panics.Inc()
+					logr.FromContextOrDiscard(ctx).Error(fmt.Errorf("panic: %v", p), "recovered from a panic while "+
+						"handling a feature", "fqn", ft.FQN, "stack", string(debug.Stack()))
+					errs[i] = &featureError{FQN: ft.FQN, err: fmt.Errorf("panic: %v", p)}
 				}
+			}()
+			if err := m.handleFeature(ctx, ft, body, md, bs); err != nil {
+				errs[i] = &featureError{FQN: ft.FQN, err: err}
 			}
-			pm := dynamicpb.NewMessage(md)
-			err = proto.Unmarshal(msg.Body, pm)
-			if err != nil {
-				return fmt.Errorf("failed to parse message to proto: %w", err)
-			}
+		}(i, ft)
+	}
+	wg.Wait()
+	return stdErrors.Join(errs...)
+}
 
-			// marshal to the row map
-			jsonMsg, err = protojson.Marshal(pm)
-			if err != nil {
-				return fmt.Errorf("failed to marshal proto to json: %w", err)
-			}
-		} else {
-			jsonMsg = msg.Body
+// handleFeature computes a single feature of the message
+func (m *manager) handleFeature(ctx context.Context, ft *Feature, body []byte, md brokers.Metadata, bs BaseStreaming) error {
+	ctx = logr.NewContext(ctx, logr.FromContextOrDiscard(ctx).WithValues("fqn", ft.FQN))
+
+	var row map[string]any
+	if len(body) == 0 {
+		// tombstones have nothing to decode or enrich, and are keyed by the broker's message key
+		row = map[string]any{TombstoneField: true}
+	} else {
+		jsonMsg, err := decode(body, ft, bs)
+		if err != nil {
+			return err
 		}
 
-		// if schema is not provided, we assume that the message is a json
 		// now we need to unmarshal it to a map
-		err := json.Unmarshal(jsonMsg, &row)
+		err = json.Unmarshal(jsonMsg, &row)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal message: %w", err)
+			return &encodingError{fmt.Errorf("failed to unmarshal message: %w", err)}
 		}
 		row = flattenMap(row)
 
-		keys := api.Keys{}
-		for _, k := range ft.Keys {
-			if _, ok := row[k]; !ok {
-				return fmt.Errorf("key %s is missing in the message", k)
+		if bs.enricher != nil {
+			if err := bs.enricher.enrich(ctx, row); err != nil {
+				return err
 			}
-			keys[k] = fmt.Sprintf("%s", row[k])
 		}
+	}
 
-		_, _, err = m.runtimeManager.ExecuteProgram(ctx, ft.RuntimeEnv, ft.FQN, keys, row, md.Timestamp, false)
-		if err != nil {
-			return fmt.Errorf("failed to execute feature: %w", err)
+	// The keys are taken from the message. When a feature has a single key that is missing in the message, the
+	// broker's message key is used instead.
+	keys := api.Keys{}
+	for _, k := range ft.Keys {
+		if v, ok := row[k]; ok {
+			keys[k] = fmt.Sprintf("%s", v)
+			continue
+		}
+		if len(ft.Keys) == 1 && md.Key != "" {
+			keys[k] = md.Key
+			continue
 		}
+		return &permanentError{fmt.Errorf("key %s is missing in the message", k)}
+	}
+	if !ft.entitySampled(keys) {
+		sampledOut.WithLabelValues(featureLabels.label(ft.FQN)).Inc()
+		return nil
+	}
+
+	err := m.execute(ctx, ft, keys, row, md)
+	ft.health.record(ctx, ft, err, bs)
+	if err != nil {
+		return fmt.Errorf("failed to execute feature: %w", err)
 	}
 	return nil
 }
 
+// featureError is a failure of a particular feature of the message
+type featureError struct {
+	FQN string
+	err error
+}
+
+func (e *featureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *featureError) Unwrap() error {
+	return e.err
+}
+
+// encodingError is a failure to decode a malformed message, that redelivering the message won't fix
+type encodingError struct {
+	err error
+}
+
+func (e *encodingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *encodingError) Unwrap() error {
+	return e.err
+}
+
+// permanentError is a failure of the message that redelivering it won't fix (i.e. a missing key), so it's dropped
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent reports whether redelivering the failed message won't help: the failure is an encoding error, a
+// permanent error, or the runtime rejected the message as invalid. A joined error (of the parallel features) is
+// permanent only if all of its errors are, since otherwise redelivering the message may succeed.
+func isPermanent(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !isPermanent(err) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var ee *encodingError
+	var pe *permanentError
+	if errors.As(err, &ee) || errors.As(err, &pe) {
+		return true
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	return errors.As(err, &se) && se.GRPCStatus().Code() == codes.InvalidArgument
+}
+
+// execute executes the feature's program, limited by the feature's timeout
+func (m *manager) execute(ctx context.Context, ft *Feature, keys api.Keys, row map[string]any, md brokers.Metadata) error {
+	if ft.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ft.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	val, retKeys, err := m.runtimeManager.ExecuteProgram(ctx, ft.RuntimeEnv, ft.FQN, keys, row, md.Timestamp, false)
+	label := featureLabels.label(ft.FQN)
+	featureExecutionDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		featureExecutions.WithLabelValues(label, "failure").Inc()
+	} else {
+		featureExecutions.WithLabelValues(label, "success").Inc()
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s %w after %s: %w", ft.FQN, errFeatureTimeout, ft.timeout, err)
+	}
+	if err == nil && m.logExecResults {
+		logr.FromContextOrDiscard(ctx).V(1).Info("executed", "value", truncate(fmt.Sprint(val.Value), m.execResultsMaxLen),
+			"keys", retKeys, "timestamp", val.Timestamp, "fresh", val.Fresh)
+	}
+	return err
+}
+
+// errFeatureTimeout is the failure of a feature that exceeded its timeout
+var errFeatureTimeout = stdErrors.New("timed out")
+
+// truncate truncates s to n bytes, if n is positive
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
+
 func flattenMap(row map[string]any) map[string]any {
 	//flatten maps
 	ret := make(map[string]any)
@@ -188,3 +652,55 @@ func flattenMap(row map[string]any) map[string]any {
 	}
 	return ret
 }
+
+// decode returns the message body as json, decoding protobuf messages by the feature's schema or the descriptor set
+func decode(body []byte, ft *Feature, bs BaseStreaming) ([]byte, error) {
+	var md protoreflect.MessageDescriptor
+	switch {
+	case ft.Schema != "":
+		u, err := url.Parse(ft.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse data schema: %w", err)
+		}
+
+		md, err = protoregistry.GetDescriptor(u.Fragment)
+		if err != nil {
+			if !errors.Is(err, protoregistry.ErrNotFound) {
+				return nil, fmt.Errorf("failed to find proto type for message")
+			}
+
+			schemas.forget(ft.Schema)
+			pack, err := registerSchema(logr.Discard(), ft.Schema)
+			if err != nil && !errors.Is(err, protoregistry.ErrAlreadyRegistered) {
+				return nil, fmt.Errorf("failed to register proto type: %w", err)
+			}
+
+			s := u.Fragment
+			if strings.Count(s, ".") < 1 {
+				s = fmt.Sprintf("%s.%s", pack, u.Fragment)
+			}
+			md, err = protoregistry.GetDescriptor(s)
+			if err != nil {
+				panic(fmt.Errorf("failed to get a schema that was just registered: %w", err))
+			}
+		}
+	case bs.descriptor != nil:
+		md = bs.descriptor
+	default:
+		// if schema is not provided, we assume that the message is a json
+		return body, nil
+	}
+
+	pm := dynamicpb.NewMessage(md)
+	err := proto.Unmarshal(body, pm)
+	if err != nil {
+		return nil, &encodingError{fmt.Errorf("failed to parse message to proto: %w", err)}
+	}
+
+	// marshal to the row map
+	jsonMsg, err := protojson.Marshal(pm)
+	if err != nil {
+		return nil, &encodingError{fmt.Errorf("failed to marshal proto to json: %w", err)}
+	}
+	return jsonMsg, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestFeatureDefinitionsSkipMissingFeatures(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	ftSpec := testFeature("clicks", "")
+	if err := h.kube.Create(context.Background(), ftSpec); err != nil {
+		t.Fatal(err)
+	}
+
+	in := &raptorApi.DataSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default"},
+		Status: raptorApi.DataSourceStatus{Features: []raptorApi.ResourceReference{
+			{Name: "deleted"},
+			{Name: "clicks"},
+		}},
+	}
+	features, pending := h.m.getFeatureDefinitions(context.Background(), in, h.bs)
+	if len(pending) != 0 {
+		t.Errorf("expected no pending features, got %v", pending)
+	}
+	list := features.List()
+	if len(list) != 1 {
+		t.Fatalf("expected only the resolvable feature, got %d features", len(list))
+	}
+	for _, ft := range list {
+		if ft == nil {
+			t.Fatal("expected no nil features")
+		}
+	}
+	if list[0].FQN != ftSpec.FQN() {
+		t.Errorf("expected feature %s, got %s", ftSpec.FQN(), list[0].FQN)
+	}
+
+	// handling a message doesn't panic on the missing feature
+	h.bs.features = features
+	h.start()
+	h.send("events", `{"id": "user-1"}`)
+	h.eventually(func() bool { return len(h.rt.executions(ftSpec.FQN())) == 1 }, "the feature wasn't executed")
+}
+
+func TestFeatureChangedMidRun(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	ftSpec := testFeature("clicks", `{"schema": "`+serveSchema(t, "changed")+`"}`)
+	if err := h.kube.Create(context.Background(), ftSpec); err != nil {
+		t.Fatal(err)
+	}
+	h.m.Add(h.ctx, dataSource(nil, "clicks"))
+	h.eventually(func() bool { return h.m.Ready(h.ctx) }, "the DataSource isn't ready")
+
+	// a `changed.Click` message of `id` "user-1"
+	click := "\x0a\x06user-1"
+	fqn := h.m.bs.features.List()[0].FQN
+	h.send("events", click)
+	h.eventually(func() bool { return len(h.rt.executions(fqn)) == 1 }, "the feature wasn't executed")
+
+	// the program changes, and the feature (along with its schema) is reloaded
+	ftSpec.Spec.Builder.Code = "def clicks(**req):\n    return 2"
+	ftSpec.Generation++
+	if err := h.kube.Update(context.Background(), ftSpec); err != nil {
+		t.Fatal(err)
+	}
+	h.m.reloadFeature(h.ctx, ftSpec, true)
+
+	h.send("events", click)
+	h.eventually(func() bool { return len(h.rt.executions(fqn)) == 2 }, "the changed feature wasn't executed")
+	execs := h.rt.executions(fqn)
+	if execs[1].Program != ftSpec.Spec.Builder.Code {
+		t.Errorf("expected the changed program to be executed, got %q", execs[1].Program)
+	}
+	if execs[1].Keys["id"] != "user-1" {
+		t.Errorf("expected the message to be decoded with the schema, got keys %v", execs[1].Keys)
+	}
+	if n := h.subscribes.Load(); n != 1 {
+		t.Errorf("expected the feature to be reloaded without resubscribing, got %d subscribes", n)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"sync"
+	"time"
+)
+
+// defaultFeatureCooldown is the time a failing feature is disabled for, unless configured otherwise
+const defaultFeatureCooldown = 5 * time.Minute
+
+// featureHealth tracks the consecutive execution failures of a feature, to disable a consistently failing feature
+// (i.e. a bad program) instead of failing every message. A reloaded feature starts healthy.
+type featureHealth struct {
+	mu            sync.Mutex
+	failures      int
+	disabledUntil time.Time
+}
+
+// disabled reports whether the feature is disabled
+func (h *featureHealth) disabled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Now().Before(h.disabledUntil)
+}
+
+// record tracks the result of an execution, and disables the feature for the cooldown once it failed
+// `FeatureFailureThreshold` consecutive times. Failures that aren't the feature's fault (i.e. the runtime is
+// unavailable, or the message is being abandoned) aren't counted.
+func (h *featureHealth) record(ctx context.Context, ft *Feature, err error, bs BaseStreaming) {
+	if bs.FeatureFailureThreshold <= 0 {
+		return
+	}
+	// a feature exceeding its own timeout is the feature's fault, although its deadline error looks like an outage
+	if err != nil && !errors.Is(err, errFeatureTimeout) && (isUnavailable(err) || errors.Is(err, context.Canceled)) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures < bs.FeatureFailureThreshold {
+		return
+	}
+
+	cooldown := bs.FeatureCooldown
+	if cooldown <= 0 {
+		cooldown = defaultFeatureCooldown
+	}
+	h.failures = 0
+	h.disabledUntil = time.Now().Add(cooldown)
+	featuresDisabled.WithLabelValues(featureLabels.label(ft.FQN)).Inc()
+	logr.FromContextOrDiscard(ctx).Error(err, "feature keeps failing, disabling it", "cooldown", cooldown,
+		"failures", bs.FeatureFailureThreshold)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/raptor-ml/raptor/api"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestFailingFeatureIsDisabled(t *testing.T) {
+	h := newHarness(t, raptorApi.ParsedConfig{
+		"feature_failure_threshold": "3",
+		"feature_cooldown":          "1m",
+		"parallel_features":         "true",
+	})
+	broken := h.addFeature(testFeature("broken", ""))
+	healthy := h.addFeature(testFeature("healthy", ""))
+	h.rt.execute = func(_ context.Context, fqn string, _ api.Keys, _ map[string]any) error {
+		if fqn == broken.FQN {
+			return errors.New("division by zero")
+		}
+		return nil
+	}
+
+	for i := 0; i < 5; i++ {
+		msg := &pubsub.Message{Body: []byte(`{"id": "user-1"}`)}
+		_, _ = h.m.handle(context.Background(), msg, brokers.Metadata{Topic: "events"}, h.bs)
+	}
+
+	if n := len(h.rt.executions(broken.FQN)); n != 3 {
+		t.Errorf("expected the failing feature to be disabled after 3 failures, got %d executions", n)
+	}
+	if !broken.health.disabled() {
+		t.Error("expected the failing feature to be disabled")
+	}
+	if n := len(h.rt.executions(healthy.FQN)); n != 5 {
+		t.Errorf("expected the healthy feature to keep executing, got %d executions", n)
+	}
+}
+
+func TestRuntimeOutageDoesntDisableFeatures(t *testing.T) {
+	h := newHarness(t, raptorApi.ParsedConfig{"feature_failure_threshold": "3"})
+	ft := h.addFeature(testFeature("clicks", ""))
+	h.rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		return status.Error(codes.Unavailable, "connection refused")
+	}
+
+	for i := 0; i < 5; i++ {
+		msg := &pubsub.Message{Body: []byte(`{"id": "user-1"}`)}
+		_, _ = h.m.handle(context.Background(), msg, brokers.Metadata{Topic: "events"}, h.bs)
+	}
+	if ft.health.disabled() {
+		t.Error("expected the feature to stay enabled while the runtime is unavailable")
+	}
+	if n := len(h.rt.executions(ft.FQN)); n != 5 {
+		t.Errorf("expected the feature to keep executing, got %d executions", n)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"sync"
+)
+
+// featureSet is the set of the DataSource's features, that can be updated while the workers are running
+type featureSet struct {
+	mu       sync.RWMutex
+	features map[client.ObjectKey]*Feature
+	list     []*Feature
+}
+
+func newFeatureSet() *featureSet {
+	return &featureSet{features: make(map[client.ObjectKey]*Feature)}
+}
+
+// List returns a snapshot of the features, ordered by their FQN
+func (s *featureSet) List() []*Feature {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list
+}
+
+// Has reports whether the feature is in the set
+func (s *featureSet) Has(key client.ObjectKey) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.features[key]
+	return ok
+}
+
+// Set adds or replaces a feature
+func (s *featureSet) Set(key client.ObjectKey, ft *Feature) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.features[key] = ft
+	s.rebuild()
+}
+
+// Delete removes a feature
+func (s *featureSet) Delete(key client.ObjectKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.features, key)
+	s.rebuild()
+}
+
+// rebuild replaces the snapshot, so the workers holding the previous one are unaffected
+func (s *featureSet) rebuild() {
+	list := make([]*Feature, 0, len(s.features))
+	for _, ft := range s.features {
+		list = append(list, ft)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FQN < list[j].FQN
+	})
+	s.list = list
+}
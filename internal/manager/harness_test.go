@@ -0,0 +1,436 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr/testr"
+	"github.com/raptor-ml/raptor/api"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+	"gocloud.dev/pubsub/mempubsub"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"net/http"
+	"net/http/httptest"
+	ctrlCache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	brokers.Register(memBrokerKind, memBroker{})
+}
+
+// memBrokerKind is the kind of the in-memory broker of the tests, which is subscribed to by the harness
+const memBrokerKind = "mem"
+
+// memBroker extracts the message metadata from the `id` and `key` headers
+type memBroker struct{}
+
+func (memBroker) Metadata(_ context.Context, msg *pubsub.Message) brokers.Metadata {
+	return brokers.Metadata{ID: msg.Metadata["id"], Key: msg.Metadata["key"]}
+}
+
+// Subscribe subscribes to the topics of the harness of the context, if any
+func (memBroker) Subscribe(ctx context.Context, cfg raptorApi.ParsedConfig) (context.Context, []brokers.Subscription, error) {
+	h, ok := ctx.Value(harnessKey{}).(*harness)
+	if !ok {
+		return ctx, nil, nil
+	}
+	return ctx, h.subscribe(cfg), nil
+}
+
+// harnessKey is the context key of the harness, for the in-memory broker to subscribe to its topics
+type harnessKey struct{}
+
+// fakeSubscription is a subscription driver whose receives fail with the error code, or block until cancelled
+// when it's gcerrors.OK. Closing it blocks until `closing` is closed, if set.
+type fakeSubscription struct {
+	code    gcerrors.ErrorCode
+	closing chan struct{}
+}
+
+func (s *fakeSubscription) ReceiveBatch(ctx context.Context, _ int) ([]*driver.Message, error) {
+	if s.code != gcerrors.OK {
+		return nil, fmt.Errorf("receive failed: %s", s.code)
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *fakeSubscription) SendAcks(context.Context, []driver.AckID) error  { return nil }
+func (s *fakeSubscription) CanNack() bool                                   { return true }
+func (s *fakeSubscription) SendNacks(context.Context, []driver.AckID) error { return nil }
+func (s *fakeSubscription) IsRetryable(error) bool                          { return false }
+func (s *fakeSubscription) As(any) bool                                     { return false }
+func (s *fakeSubscription) ErrorAs(error, any) bool                         { return false }
+func (s *fakeSubscription) ErrorCode(error) gcerrors.ErrorCode              { return s.code }
+
+func (s *fakeSubscription) Close() error {
+	if s.closing != nil {
+		<-s.closing
+	}
+	return nil
+}
+
+// fakeRuntime is an api.RuntimeManager that records the loaded and executed programs
+type fakeRuntime struct {
+	mu       sync.Mutex
+	loads    map[string]int
+	programs map[string]string
+	execs    map[string][]fakeExecution
+
+	// execute, if set, is called on every execution and returns its error
+	execute func(ctx context.Context, fqn string, keys api.Keys, row map[string]any) error
+}
+
+type fakeExecution struct {
+	Keys    api.Keys
+	Row     map[string]any
+	Program string
+	Err     error
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{loads: make(map[string]int), programs: make(map[string]string),
+		execs: make(map[string][]fakeExecution)}
+}
+
+func (r *fakeRuntime) LoadProgram(_, fqn, program string, _ []string) (*api.ParsedProgram, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loads[fqn]++
+	r.programs[fqn] = program
+	return &api.ParsedProgram{}, nil
+}
+
+func (r *fakeRuntime) ExecuteProgram(ctx context.Context, _ string, fqn string, keys api.Keys, row map[string]any, ts time.Time, _ bool) (api.Value, api.Keys, error) {
+	var err error
+	if r.execute != nil {
+		err = r.execute(ctx, fqn, keys, row)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.execs[fqn] = append(r.execs[fqn], fakeExecution{Keys: keys, Row: row, Program: r.programs[fqn], Err: err})
+	if err != nil {
+		return api.Value{}, nil, err
+	}
+	return api.Value{Timestamp: ts}, keys, nil
+}
+
+func (r *fakeRuntime) GetSidecars() []corev1.Container {
+	return nil
+}
+
+func (r *fakeRuntime) GetDefaultEnv() string {
+	return "default"
+}
+
+// loaded returns the number of times the program of the feature was loaded
+func (r *fakeRuntime) loaded(fqn string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loads[fqn]
+}
+
+// executions returns the executions of the feature
+func (r *fakeRuntime) executions(fqn string) []fakeExecution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fakeExecution(nil), r.execs[fqn]...)
+}
+
+// fakeCache serves the Kubernetes objects of the manager from a fake client
+type fakeCache struct {
+	ctrlCache.Cache
+	client client.Client
+}
+
+func (c *fakeCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.client.Get(ctx, key, obj, opts...)
+}
+
+func (c *fakeCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.client.List(ctx, list, opts...)
+}
+
+func (c *fakeCache) GetInformer(context.Context, client.Object, ...ctrlCache.InformerGetOption) (ctrlCache.Informer, error) {
+	return fakeInformer{}, nil
+}
+
+func (c *fakeCache) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// fakeInformer accepts the event handlers of the manager, without ever calling them
+type fakeInformer struct {
+	ctrlCache.Informer
+}
+
+func (fakeInformer) AddEventHandler(toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error) {
+	return nil, nil
+}
+
+// testFeature returns a streaming Feature resource keyed by `id`, with the builder's custom configuration
+func testFeature(name, builder string) *raptorApi.Feature {
+	if builder == "" {
+		builder = "{}"
+	}
+	return &raptorApi.Feature{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: raptorApi.FeatureSpec{
+			Primitive: "int",
+			Keys:      []string{"id"},
+			Builder: raptorApi.FeatureBuilder{
+				Kind: "streaming",
+				Code: "def " + name + "(**req):\n    return 1",
+				Raw:  json.RawMessage(builder),
+			},
+		},
+	}
+}
+
+// harness runs a manager against in-memory topics and a fake runtime
+type harness struct {
+	t      *testing.T
+	m      *manager
+	rt     *fakeRuntime
+	bs     BaseStreaming
+	kube   client.Client
+	topics map[string]*pubsub.Topic
+	names  []string
+
+	// ctx is the context of the DataSource, for the in-memory broker to subscribe to the topics when it's added
+	ctx context.Context
+
+	// subscribes is the number of times the in-memory broker subscribed to the topics
+	subscribes atomic.Int32
+
+	// mu guards the config the in-memory broker last subscribed with
+	mu  sync.Mutex
+	cfg raptorApi.ParsedConfig
+
+	// newSubscription, if set, opens the subscriptions of the in-memory broker instead of the in-memory topics
+	newSubscription func(topic string) *pubsub.Subscription
+}
+
+// newHarness returns a harness of the streaming config, with a subscription per topic
+func newHarness(t *testing.T, cfg raptorApi.ParsedConfig, topics ...string) *harness {
+	t.Helper()
+	if cfg == nil {
+		cfg = raptorApi.ParsedConfig{}
+	}
+	cfg["kind"] = memBrokerKind
+	bs, broker, err := parseBaseStreaming(cfg)
+	if err != nil {
+		t.Fatalf("invalid streaming config: %s", err)
+	}
+	bs.features = newFeatureSet()
+	bs.inFlight = &inFlight{}
+	bs.mdExtractor = broker.Metadata
+
+	scheme := runtime.NewScheme()
+	if err := raptorApi.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{
+		t:      t,
+		rt:     newFakeRuntime(),
+		kube:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+		topics: make(map[string]*pubsub.Topic),
+		names:  topics,
+	}
+	h.m = &manager{logger: testr.New(t), runtimeManager: h.rt, client: &fakeCache{client: h.kube},
+		shutdownTimeout: time.Second}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), harnessKey{}, h))
+	t.Cleanup(cancel)
+	h.ctx = ctx
+	for _, name := range topics {
+		topic := mempubsub.NewTopic()
+		sub := mempubsub.NewSubscription(topic, time.Minute)
+		h.topics[name] = topic
+		bs.subscriptions = append(bs.subscriptions, brokers.Subscription{Subscription: sub, Topic: name})
+		t.Cleanup(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = sub.Shutdown(ctx)
+			_ = topic.Shutdown(ctx)
+		})
+	}
+	h.bs = bs
+	return h
+}
+
+// subscribe opens new subscriptions to the topics, as the in-memory broker does when the DataSource is added
+func (h *harness) subscribe(cfg raptorApi.ParsedConfig) []brokers.Subscription {
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+	h.subscribes.Add(1)
+	var subs []brokers.Subscription
+	for _, name := range h.names {
+		var sub *pubsub.Subscription
+		if h.newSubscription != nil {
+			sub = h.newSubscription(name)
+		} else {
+			sub = mempubsub.NewSubscription(h.topics[name], time.Minute)
+		}
+		subs = append(subs, brokers.Subscription{Subscription: sub, Topic: name})
+	}
+	return subs
+}
+
+// config returns the config the in-memory broker last subscribed with
+func (h *harness) config() raptorApi.ParsedConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cfg
+}
+
+// dataSource returns a streaming DataSource of the in-memory broker, with the config and the features
+func dataSource(cfg map[string]string, features ...string) *raptorApi.DataSource {
+	in := &raptorApi.DataSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default", Generation: 1},
+		Spec: raptorApi.DataSourceSpec{
+			Kind:   "streaming",
+			Config: []raptorApi.ConfigVar{{Name: "kind", Value: memBrokerKind}},
+		},
+	}
+	for k, v := range cfg {
+		in.Spec.Config = append(in.Spec.Config, raptorApi.ConfigVar{Name: k, Value: v})
+	}
+	for _, name := range features {
+		in.Status.Features = append(in.Status.Features, raptorApi.ResourceReference{Name: name, Namespace: "default"})
+	}
+	return in
+}
+
+// serveSchema serves a proto schema of a `Click` message in the package, and returns its url
+func serveSchema(t *testing.T, pkg string) string {
+	t.Helper()
+	schema := fmt.Sprintf("syntax = \"proto3\";\npackage %s;\nmessage Click {\n  string id = 1;\n}\n", pkg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(schema))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/" + pkg + ".proto#" + pkg + ".Click"
+}
+
+// addFeature creates the Feature resource, and loads it as the manager does
+func (h *harness) addFeature(ftSpec *raptorApi.Feature) *Feature {
+	h.t.Helper()
+	if err := h.kube.Create(context.Background(), ftSpec); err != nil {
+		h.t.Fatalf("failed to create feature: %s", err)
+	}
+	ref := ftSpec.ResourceReference()
+	ft, err := h.m.getFeature(context.Background(), ref, h.bs)
+	if err != nil {
+		h.t.Fatalf("failed to load feature: %s", err)
+	}
+	h.bs.features.Set(ref.ObjectKey(), ft)
+	return ft
+}
+
+// start receives the messages of the topics until the test ends
+func (h *harness) start() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(cancel)
+	h.m.mu.Lock()
+	h.m.bs = &h.bs
+	h.m.mu.Unlock()
+	h.m.subscribe(ctx, ctx, h.bs)
+	h.m.mu.Lock()
+	h.m.ready = true
+	h.m.mu.Unlock()
+	return ctx
+}
+
+// send publishes a message to the topic, with the metadata as `key=value` pairs
+func (h *harness) send(topic, body string, md ...string) {
+	h.t.Helper()
+	msg := &pubsub.Message{Body: []byte(body), Metadata: make(map[string]string)}
+	for i := 0; i+1 < len(md); i += 2 {
+		msg.Metadata[md[i]] = md[i+1]
+	}
+	if err := h.topics[topic].Send(context.Background(), msg); err != nil {
+		h.t.Fatalf("failed to send message: %s", err)
+	}
+}
+
+// receive receives n messages of the topics directly, by their body
+func (h *harness) receive(n int) map[string]received {
+	h.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ret := make(map[string]received)
+	for len(ret) < n {
+		for _, sub := range h.bs.subscriptions {
+			rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			msg, err := sub.Receive(rctx)
+			rcancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					h.t.Fatalf("received %d out of %d messages", len(ret), n)
+				}
+				continue
+			}
+			ret[string(msg.Body)] = received{msg: msg, sub: sub, inFlight: h.bs.inFlight}
+		}
+	}
+	return ret
+}
+
+// eventually fails the test if the condition isn't met within a few seconds
+func (h *harness) eventually(cond func() bool, msg string) {
+	h.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// consistently fails the test if the condition isn't met for a short while
+func (h *harness) consistently(cond func() bool, msg string) {
+	h.t.Helper()
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if !cond() {
+			h.t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
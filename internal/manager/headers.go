@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"regexp"
+	"strings"
+)
+
+// metadataKeyPattern is the format of gRPC metadata keys
+var metadataKeyPattern = regexp.MustCompile(`^[0-9a-z_.-]+$`)
+
+// binaryMetadataSuffix is the suffix of binary gRPC metadata keys
+const binaryMetadataSuffix = "-bin"
+
+// reservedMetadataKeys are gRPC metadata keys that the runner sets, and shouldn't be controlled by the messages
+var reservedMetadataKeys = []string{"authorization", "traceparent", "tracestate"}
+
+// parseForwardHeaders parses a list of `header` or `header=metadata-key` pairs, mapping the message headers to the
+// gRPC metadata keys they're forwarded as
+func parseForwardHeaders(pairs []string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, p := range pairs {
+		header, key, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			key = header
+		}
+		key = strings.ToLower(key)
+		if header == "" || !metadataKeyPattern.MatchString(key) || strings.HasPrefix(key, "grpc-") ||
+			strings.HasSuffix(key, binaryMetadataSuffix) {
+			return nil, fmt.Errorf("`%s` is not `name` or `name=metadata-key` with a valid metadata key", p)
+		}
+		for _, r := range reservedMetadataKeys {
+			if key == r {
+				return nil, fmt.Errorf("forwarding `%s` as the reserved `%s` metadata is not allowed", header, key)
+			}
+		}
+		ret[header] = key
+	}
+	return ret, nil
+}
+
+// withForwardedHeaders adds the mapped message headers to the outgoing gRPC metadata of the runtime calls.
+// Headers with values that aren't printable ASCII (i.e. binary values) are forwarded as the binary `<key>-bin`
+// metadata instead, which gRPC base64 encodes on the wire, so they aren't mangled.
+func withForwardedHeaders(ctx context.Context, headers map[string]string, forward map[string]string) context.Context {
+	var kv []string
+	for header, key := range forward {
+		v, ok := headers[header]
+		if !ok {
+			continue
+		}
+		if !printableASCII(v) {
+			key += binaryMetadataSuffix
+		}
+		kv = append(kv, key, v)
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// dataSourceMetadata returns the gRPC metadata pairs of the DataSource's labels and annotations that are forwarded to
+// the runtime (i.e. a tenant label), mapped by `ForwardLabels` and `ForwardAnnotations`. Missing ones are skipped.
+func dataSourceMetadata(labels, annotations map[string]string, bs BaseStreaming) ([]string, error) {
+	var kv []string
+	for _, f := range []struct {
+		name   string
+		pairs  []string
+		values map[string]string
+	}{
+		{"label", bs.ForwardLabels, labels},
+		{"annotation", bs.ForwardAnnotations, annotations},
+	} {
+		forward, err := parseForwardHeaders(f.pairs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forwarded %s: %w", f.name, err)
+		}
+		for name, key := range forward {
+			v, ok := f.values[name]
+			if !ok {
+				continue
+			}
+			if !printableASCII(v) {
+				key += binaryMetadataSuffix
+			}
+			kv = append(kv, key, v)
+		}
+	}
+	return kv, nil
+}
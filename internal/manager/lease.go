@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"time"
+)
+
+// ackExtensionInterval is the interval the ack deadline of a message being handled is extended by
+const ackExtensionInterval = 10 * time.Second
+
+// extendAckDeadline periodically extends the ack deadline of the message while it's being handled, up to
+// MaxAckExtension, so a slow handling isn't redelivered and processed twice. The returned func stops extending.
+func (m *manager) extendAckDeadline(ctx context.Context, r received, bs BaseStreaming) func() {
+	if bs.MaxAckExtension <= 0 || r.sub.ExtendDeadline == nil {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		deadline := time.Now().Add(bs.MaxAckExtension)
+		ticker := time.NewTicker(ackExtensionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// keep a margin of an interval, so the extension lands before the current deadline
+			d := min(2*ackExtensionInterval, time.Until(deadline))
+			if d <= 0 {
+				return
+			}
+			if err := r.sub.ExtendDeadline(ctx, r.msg, d); err != nil && ctx.Err() == nil {
+				logr.FromContextOrDiscard(ctx).Error(err, "failed to extend the ack deadline")
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
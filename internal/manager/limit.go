@@ -0,0 +1,51 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"github.com/raptor-ml/raptor/api"
+	"time"
+)
+
+type limitedRuntime struct {
+	api.RuntimeManager
+	sem chan struct{}
+}
+
+// WithConcurrencyLimit wraps the RuntimeManager so at most `limit` programs are executed concurrently, regardless of
+// the number of workers and features. Executions beyond the limit wait for a slot, or until their context is done.
+func WithConcurrencyLimit(rm api.RuntimeManager, limit int) api.RuntimeManager {
+	if limit <= 0 {
+		return rm
+	}
+	return &limitedRuntime{RuntimeManager: rm, sem: make(chan struct{}, limit)}
+}
+
+func (l *limitedRuntime) ExecuteProgram(ctx context.Context, env string, fqn string, keys api.Keys, row map[string]any, ts time.Time, dryRun bool) (api.Value, api.Keys, error) {
+	select {
+	case <-ctx.Done():
+		return api.Value{}, nil, ctx.Err()
+	case l.sem <- struct{}{}:
+	}
+	concurrentExecutions.Inc()
+	defer func() {
+		concurrentExecutions.Dec()
+		<-l.sem
+	}()
+	return l.RuntimeManager.ExecuteProgram(ctx, env, fqn, keys, row, ts, dryRun)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/raptor/api"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimitIsNeverExceeded(t *testing.T) {
+	const limit = 2
+	h := newHarness(t, raptorApi.ParsedConfig{"workers": "8"}, "events")
+	h.m.runtimeManager = WithConcurrencyLimit(h.rt, limit)
+	var fqns []string
+	for _, name := range []string{"clicks", "views", "orders"} {
+		fqns = append(fqns, h.addFeature(testFeature(name, "")).FQN)
+	}
+
+	var current, peak atomic.Int32
+	h.rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+	h.start()
+
+	const messages = 20
+	for i := 0; i < messages; i++ {
+		h.send("events", fmt.Sprintf(`{"id": "user-%d"}`, i), "id", fmt.Sprintf("msg-%d", i))
+	}
+	h.eventually(func() bool {
+		for _, fqn := range fqns {
+			if len(h.rt.executions(fqn)) < messages {
+				return false
+			}
+		}
+		return true
+	}, "the messages weren't executed")
+	if p := peak.Load(); p > limit {
+		t.Errorf("expected at most %d concurrent executions, got %d", limit, p)
+	}
+}
@@ -18,41 +18,166 @@ package manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/google/uuid"
 	"github.com/raptor-ml/raptor/api"
 	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
-	"github.com/raptor-ml/raptor/pkg/protoregistry"
 	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"github.com/raptor-ml/streaming-runner/pkg/transforms"
+	"github.com/sony/gobreaker"
+	"gocloud.dev/gcerrors"
 	"gocloud.dev/pubsub"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"math/rand"
 	"net/url"
 	ctrlCache "sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Manager interface {
 	Start(context.Context) error
 	Ready(context.Context) bool
+
+	// Pause stops receiving messages, while keeping the subscription alive
+	Pause()
+	// Resume resumes receiving messages. It fails with ErrDraining once draining.
+	Resume() error
+	// Paused reports whether receiving messages is paused
+	Paused() bool
+	// Err returns the reason the manager is not ready, if known
+	Err() error
+	// Dump logs the current state of the manager
+	Dump()
+	// Drain stops receiving messages, and waits for the in-flight messages to complete
+	Drain(context.Context) error
 }
 type manager struct {
 	client         ctrlCache.Cache
 	logger         logr.Logger
-	cancel         context.CancelFunc
 	src            client.ObjectKey
 	runtimeManager api.RuntimeManager
-	bs             *BaseStreaming
-	ready          bool
+	pauser
+
+	// lifecycle serializes the (re)subscriptions
+	lifecycle         sync.Mutex
+	resubscribes      atomic.Int32
+	featureSelector   labels.Selector
+	warmupDelay       time.Duration
+	logExecResults    bool
+	execResultsMaxLen int
+	warmedUp          atomic.Bool
+	draining          atomic.Bool
+
+	skipSchemaRegistration bool
+	idleTimeout            time.Duration
+	featureRefresh         time.Duration
+	shutdownTimeout        time.Duration
+
+	// shutdowns tracks the subscriptions being shut down
+	shutdowns sync.WaitGroup
+
+	// lastReceive is the time of the last received message per topic
+	lastReceive sync.Map
+
+	// mu guards the state below, which is mutated by the informer's handlers
+	mu     sync.RWMutex
+	in     *raptorApi.DataSource
+	bs     *BaseStreaming
+	cancel context.CancelFunc
+	ready  bool
+	err    error
 }
 
-func New(src client.ObjectKey, rm api.RuntimeManager, cfg *rest.Config, logger logr.Logger) (Manager, error) {
+// Option configures the manager
+type Option func(*manager)
+
+// WithFeatureSelector limits the features to the ones matching the label selector, in addition to the DataSource's
+// `feature_selector`
+func WithFeatureSelector(s labels.Selector) Option {
+	return func(m *manager) {
+		m.featureSelector = s
+	}
+}
+
+// WithWarmupDelay delays receiving the first messages after startup, to let the runtime load the programs.
+// The manager isn't ready until the delay elapses.
+func WithWarmupDelay(d time.Duration) Option {
+	return func(m *manager) {
+		m.warmupDelay = d
+	}
+}
+
+// WithExecResultsLogging logs the results of the programs' executions at the debug level, truncated to maxLen bytes
+// (unless it's 0). The results may contain sensitive data.
+func WithExecResultsLogging(maxLen int) Option {
+	return func(m *manager) {
+		m.logExecResults = true
+		m.execResultsMaxLen = maxLen
+	}
+}
+
+// WithoutSchemaRegistration skips registering the schemas when the DataSource is added, as the DataSource's
+// `skip_schema_registration` does
+func WithoutSchemaRegistration() Option {
+	return func(m *manager) {
+		m.skipSchemaRegistration = true
+	}
+}
+
+// WithIdleResubscribe resubscribes when no message was received on a topic for the timeout, to recover from
+// subscriptions that silently stopped delivering. Topics that are legitimately idle for long can be exempted with the
+// DataSource's `idle_topics`.
+func WithIdleResubscribe(timeout time.Duration) Option {
+	return func(m *manager) {
+		m.idleTimeout = timeout
+	}
+}
+
+// WithFeatureRefresh periodically reconciles the features with the current Features, in addition to the informer's
+// events, to recover from missed events
+func WithFeatureRefresh(interval time.Duration) Option {
+	return func(m *manager) {
+		m.featureRefresh = interval
+	}
+}
+
+// defaultShutdownTimeout bounds the shutdown of the subscriptions, unless configured otherwise
+const defaultShutdownTimeout = 30 * time.Second
+
+// WithShutdownTimeout bounds the time to wait for the subscriptions to shut down, so an unresponsive broker doesn't
+// block the termination past the grace period
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(m *manager) {
+		m.shutdownTimeout = timeout
+	}
+}
+
+// New returns a manager of the DataSource.
+// The manager caches the DataSource and its Features, and the Secrets and ConfigMaps of the DataSource's namespace,
+// to read the referenced credentials and programs, and to reload them when they change. Field selectors can't select
+// the referenced names only, so the runner's service account needs `get`, `list` and `watch` on `secrets` and
+// `configmaps` in the namespace.
+func New(src client.ObjectKey, rm api.RuntimeManager, cfg *rest.Config, logger logr.Logger, opts ...Option) (Manager, error) {
 	c, err := ctrlCache.New(cfg, ctrlCache.Options{
 		DefaultNamespaces: map[string]ctrlCache.Config{
-			src.Namespace: {
-				FieldSelector: fields.OneTermEqualSelector("metadata.name", src.Name),
+			src.Namespace: {},
+		},
+		ByObject: map[client.Object]ctrlCache.ByObject{
+			&raptorApi.DataSource{}: {
+				Field: fields.OneTermEqualSelector("metadata.name", src.Name),
 			},
 		},
 	})
@@ -60,15 +185,47 @@ func New(src client.ObjectKey, rm api.RuntimeManager, cfg *rest.Config, logger l
 		return nil, fmt.Errorf("failed to create controler cache client: %w", err)
 	}
 
-	return &manager{
-		client:         c,
-		logger:         logger,
-		runtimeManager: rm,
-	}, nil
+	m := &manager{
+		client:          c,
+		logger:          logger,
+		src:             src,
+		runtimeManager:  rm,
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
 }
 
 func (m *manager) Ready(_ context.Context) bool {
-	return m.ready
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ready && !m.Paused() && !m.draining.Load()
+}
+
+func (m *manager) Err() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+// stop cancels the current broker context, if any
+func (m *manager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	m.bs = nil
+	m.ready = false
+}
+
+func (m *manager) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
 }
 
 func (m *manager) Start(ctx context.Context) error {
@@ -77,16 +234,20 @@ func (m *manager) Start(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	i, err := m.client.GetInformer(ctx, &raptorApi.DataSource{})
+	i, err := m.getInformer(ctx, &raptorApi.DataSource{})
 	if err != nil {
 		return fmt.Errorf("failed to get DataSource informer: %w", err)
 	}
 
 	_, err = i.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
 			m.Add(ctx, obj.(*raptorApi.DataSource))
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
 			m.Update(ctx, oldObj.(*raptorApi.DataSource), newObj.(*raptorApi.DataSource))
 		},
 		DeleteFunc: func(obj interface{}) {
@@ -97,28 +258,378 @@ func (m *manager) Start(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to add DataSource event handler: %w", err)
 	}
+
+	fi, err := m.getInformer(ctx, &raptorApi.Feature{})
+	if err != nil {
+		return fmt.Errorf("failed to get Feature informer: %w", err)
+	}
+	_, err = fi.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			m.reloadFeature(ctx, obj.(*raptorApi.Feature), false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldFt, newFt := oldObj.(*raptorApi.Feature), newObj.(*raptorApi.Feature)
+			// labels changes don't bump the generation, but may change the feature's selection
+			if oldFt.Generation == newFt.Generation && labels.Equals(oldFt.Labels, newFt.Labels) {
+				return
+			}
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			m.reloadFeature(ctx, newFt, true)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = d.Obj
+			}
+			ft, ok := obj.(*raptorApi.Feature)
+			if !ok {
+				return
+			}
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			m.removeFeature(ft)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add Feature event handler: %w", err)
+	}
+
+	// the Secrets and ConfigMaps are watched namespace-wide, and filtered by the DataSource's references (see New)
+	si, err := m.getInformer(ctx, &corev1.Secret{})
+	if err != nil {
+		return fmt.Errorf("failed to get Secret informer: %w", err)
+	}
+	_, err = si.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			m.secretChanged(ctx, oldObj.(*corev1.Secret), newObj.(*corev1.Secret))
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add Secret event handler: %w", err)
+	}
+
+	ci, err := m.getInformer(ctx, &corev1.ConfigMap{})
+	if err != nil {
+		return fmt.Errorf("failed to get ConfigMap informer: %w", err)
+	}
+	_, err = ci.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, newObj interface{}) {
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			m.configMapChanged(ctx, newObj.(*corev1.ConfigMap))
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add ConfigMap event handler: %w", err)
+	}
 	go func() {
 		<-ctx.Done()
-		if m.cancel != nil {
-			m.cancel()
-		}
+		m.stop()
 	}()
 
-	return m.client.Start(ctx)
+	err = m.client.Start(ctx)
+
+	// wait for the subscriptions to shut down, so the in-flight acks are flushed
+	done := make(chan struct{})
+	go func() {
+		m.shutdowns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(m.shutdownTimeout):
+		m.logger.Info("shutdown timed out, abandoning the subscriptions", "timeout", m.shutdownTimeout)
+	}
+	return err
+}
+
+// informerAttempts is the number of attempts to get an informer, to tolerate the API server being briefly
+// unreachable at boot
+const informerAttempts = 5
+
+// getInformer gets the informer of the object kind, retrying with a backoff on failure
+func (m *manager) getInformer(ctx context.Context, obj client.Object) (ctrlCache.Informer, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		i, err := m.client.GetInformer(ctx, obj)
+		if err == nil || attempt == informerAttempts {
+			return i, err
+		}
+		m.logger.Error(err, "failed to get informer, retrying", "attempt", attempt, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
 }
 
 type BaseStreaming struct {
 	BrokerKind string `mapstructure:"kind"`
 	Workers    int
 	Schema     *url.URL
+	Transforms []string `mapstructure:"transforms"`
+
+	// SkipSchemaRegistration skips registering the schemas when the DataSource is added, for schemas managed
+	// out-of-band. The schemas are then registered lazily by the first message of each schema, so it's safe as long as
+	// the schemas are reachable at that time; otherwise, the messages fail (and are redelivered) instead of startup.
+	SkipSchemaRegistration bool `mapstructure:"skip_schema_registration"`
+
+	// RetryDelay is the delay before a nacked message is redelivered, doubled on repeated failures of the same
+	// message up to MaxRetryDelay. Only applies to brokers supporting delayed redelivery.
+	RetryDelay    time.Duration `mapstructure:"retry_delay"`
+	MaxRetryDelay time.Duration `mapstructure:"max_retry_delay"`
+
+	// TopicWorkers is a list of `topic=workers` pairs, that gives the subscriptions of these topics a dedicated pool
+	// of workers. Other subscriptions share the pool of `Workers`.
+	// Only applies to brokers that open a subscription per topic.
+	TopicWorkers []string `mapstructure:"topic_workers"`
+
+	// WorkerStagger is the delay between starting the workers (jittered), to smooth the initial load on the runtime.
+	// Defaults to 10ms. Set to a negative value to start all the workers at once.
+	WorkerStagger time.Duration `mapstructure:"worker_stagger"`
+
+	// QueueSize is the number of received messages buffered for each pool of workers, to smooth bursts when the
+	// handling latency varies. Receiving blocks while the queue is full.
+	QueueSize int `mapstructure:"queue_size"`
+
+	// MaxDecompressedSize limits the size of message bodies decompressed by their `content-encoding` header
+	MaxDecompressedSize int64 `mapstructure:"max_decompressed_size"`
+
+	// MaxOutstanding bounds the messages each subscription fetches ahead of handling, for a uniform backpressure
+	// across brokers. Each broker translates it to its own setting:
+	//   - gcp-pubsub: the receive batch size (with a single receive handler)
+	//   - kafka: the consumer channel buffer size
+	//   - redis-streams: the XREADGROUP count
+	//   - pulsar: the receiver queue size
+	// Defaults to the broker's default. Broker specific subscription options take precedence.
+	MaxOutstanding int `mapstructure:"max_outstanding"`
+
+	// KeyAffinity routes the messages of the same key to the same worker of the pool, for the locality of per-entity
+	// caches. Unlike ordering, messages of a key may still be handled concurrently across redeliveries.
+	KeyAffinity bool `mapstructure:"key_affinity"`
+
+	// CompactionWindow enables compaction-aware handling (i.e. for compacted topics): the messages are held for the
+	// window, and only the latest message of each key is handled, while the superseded ones are acked unhandled.
+	// A longer window skips more updates, at the cost of the features being staler by up to the window. Messages
+	// without a key aren't compacted.
+	CompactionWindow time.Duration `mapstructure:"compaction_window"`
+
+	// MaxAckExtension extends the ack deadline of the messages being handled, up to this long, so a handling slower
+	// than the subscription's ack deadline isn't redelivered. Only applies to brokers with an ack deadline (GCP
+	// Pub/Sub), and the time spent waiting for a worker isn't extended. Redis Streams reclaims the entries idle for
+	// longer than its `claim_min_idle`, which defaults to this.
+	MaxAckExtension time.Duration `mapstructure:"max_ack_extension"`
+
+	// PriorityHeader is a message header of an integer priority (defaults to 0). The received messages are handled by
+	// their priority, higher first (i.e. fraud signals before routine events). Only the messages buffered in memory
+	// (up to QueueSize, or 100) are reordered; the broker's delivery order isn't affected.
+	PriorityHeader string `mapstructure:"priority_header"`
+
+	// TimestampFormats are the sources of the message timestamp, tried in order: `broker` for the broker's timestamp,
+	// or `rfc3339`, `epoch_ms` and `epoch_s` for parsing the `TimestampHeader` header. Defaults to `broker`.
+	// Messages without a resolvable timestamp are timestamped with the time they're handled.
+	TimestampFormats []string `mapstructure:"timestamp_formats"`
+	TimestampHeader  string   `mapstructure:"timestamp_header"`
+
+	// ParallelFeatures computes the features of a message concurrently, instead of one after the other. The
+	// concurrent executions are still bounded by the runner's concurrency limit.
+	ParallelFeatures bool `mapstructure:"parallel_features"`
+
+	// ForwardHeaders is a list of message headers forwarded to the runtime as gRPC metadata, each either `header` or
+	// `header=metadata-key`. Binary values are forwarded as the `<metadata-key>-bin` metadata. The headers are
+	// controlled by the producers, so the runtime shouldn't trust them beyond what it trusts the producers with.
+	ForwardHeaders []string `mapstructure:"forward_headers"`
+
+	// FeatureFailureThreshold disables a feature for FeatureCooldown (defaults to 5m) once its program failed this
+	// many consecutive times (i.e. a bad program), so it isn't retried on every message. Messages are handled without
+	// the disabled feature meanwhile. A change of the feature re-enables it. Set to `0` (default) to disable.
+	FeatureFailureThreshold int           `mapstructure:"feature_failure_threshold"`
+	FeatureCooldown         time.Duration `mapstructure:"feature_cooldown"`
+
+	// EmptyFeaturesPolicy is the handling of a DataSource without features, which is usually a race with the
+	// controller populating a new DataSource's features: `proceed` (default) consumes the messages regardless, and
+	// `wait` doesn't consume until the features appear.
+	EmptyFeaturesPolicy string `mapstructure:"empty_features_policy"`
+
+	// ForwardLabels and ForwardAnnotations are DataSource labels and annotations forwarded to the runtime as gRPC
+	// metadata with every message (i.e. for multi-tenant routing), each either `name` or `name=metadata-key`. Names
+	// that aren't valid metadata keys (i.e. `example.com/tenant`) must be mapped.
+	ForwardLabels      []string `mapstructure:"forward_labels"`
+	ForwardAnnotations []string `mapstructure:"forward_annotations"`
+
+	// TombstonePolicy is the handling of messages without a body (i.e. Kafka tombstones): `skip` (default) acks them
+	// without handling, and `pass` executes the features with a row of only `is_tombstone: true`, keyed by the
+	// broker's message key.
+	TombstonePolicy string `mapstructure:"tombstone_policy"`
 
-	subscription *pubsub.Subscription
-	mdExtractor  brokers.MetadataExtractor
-	features     []*Feature
+	// RedeliveryWarningThreshold logs a warning when a message is delivered for this many times, to heads-up about a
+	// poison message before the broker dead-letters it. Only applies to brokers tracking the delivery attempts.
+	RedeliveryWarningThreshold int `mapstructure:"redelivery_warning_threshold"`
+
+	// AgeWarningThreshold logs a warning when a message is older than the threshold by the time it's handled
+	AgeWarningThreshold time.Duration `mapstructure:"age_warning_threshold"`
+
+	// TopicScopedIDs prefixes the broker message id with the topic, for brokers whose ids are unique only per topic.
+	// Messages without a broker id always get a generated one.
+	TopicScopedIDs bool `mapstructure:"topic_scoped_ids"`
+
+	// FailureTopic is a topic url (i.e. `gcppubsub://projects/myproject/topics/failures`) that structured records
+	// of the messages that failed to be handled are published to
+	FailureTopic string `mapstructure:"failure_topic"`
+
+	// IdleTopics are topics that are legitimately idle for long, which the idle watchdog doesn't resubscribe
+	IdleTopics []string `mapstructure:"idle_topics"`
+
+	// FeatureSelector is a label selector that limits the features of the DataSource to the matching ones
+	// (i.e. for canarying a feature)
+	FeatureSelector string `mapstructure:"feature_selector"`
+
+	Enrichment    `mapstructure:",squash"`
+	DescriptorSet `mapstructure:",squash"`
+	Webhook       `mapstructure:",squash"`
+
+	subscriptions   []brokers.Subscription
+	mdExtractor     brokers.MetadataExtractor
+	transforms      transforms.Pipeline
+	retries         *retryTracker
+	topicWorkers    map[string]int
+	enricher        *enricher
+	notifier        *notifier
+	descriptor      protoreflect.MessageDescriptor
+	failures        *failurePublisher
+	featureSelector labels.Selector
+	features        *featureSet
+	forwardHeaders  map[string]string
+	dsMetadata      []string
+	runtimeGate     *runtimeGate
+	inFlight        *inFlight
+}
+
+const (
+	// EmptyFeaturesProceed consumes the messages of a DataSource without features
+	EmptyFeaturesProceed = "proceed"
+	// EmptyFeaturesWait doesn't consume the messages of a DataSource until it has features
+	EmptyFeaturesWait = "wait"
+)
+
+const (
+	// TombstoneSkip acks messages without a body, without handling them
+	TombstoneSkip = "skip"
+	// TombstonePass handles messages without a body as tombstones
+	TombstonePass = "pass"
+	// TombstoneField is the field set on the row of tombstones
+	TombstoneField = "is_tombstone"
+)
+
+// parseBaseStreaming parses and validates the streaming config, without connecting to anything
+func parseBaseStreaming(cfg raptorApi.ParsedConfig) (BaseStreaming, brokers.Broker, error) {
+	bs := BaseStreaming{}
+	err := cfg.Unmarshal(&bs)
+	if err != nil {
+		return bs, nil, fmt.Errorf("failed to unmarshal streaming config: %w", err)
+	}
+	if bs.Workers == 0 {
+		bs.Workers = 1
+	}
+	if bs.MaxDecompressedSize <= 0 {
+		bs.MaxDecompressedSize = defaultMaxDecompressedSize
+	}
+	if len(bs.TimestampFormats) == 0 {
+		bs.TimestampFormats = []string{TimestampBroker}
+	}
+	if err := validateTimestampFormats(bs.TimestampFormats); err != nil {
+		return bs, nil, err
+	}
+	if bs.TimestampHeader == "" && slices.ContainsFunc(bs.TimestampFormats, func(f string) bool {
+		return f != TimestampBroker
+	}) {
+		return bs, nil, fmt.Errorf("timestamp_header is required for parsing timestamps from a header")
+	}
+
+	switch bs.EmptyFeaturesPolicy {
+	case "", EmptyFeaturesProceed, EmptyFeaturesWait:
+	default:
+		return bs, nil, fmt.Errorf("invalid empty features policy: %s", bs.EmptyFeaturesPolicy)
+	}
+
+	switch bs.TombstonePolicy {
+	case "", TombstoneSkip, TombstonePass:
+	default:
+		return bs, nil, fmt.Errorf("invalid tombstone policy: %s", bs.TombstonePolicy)
+	}
+
+	if bs.MaxOutstanding < 0 {
+		return bs, nil, fmt.Errorf("invalid max outstanding: %d", bs.MaxOutstanding)
+	}
+	if bs.CompactionWindow < 0 {
+		return bs, nil, fmt.Errorf("invalid compaction window: %s", bs.CompactionWindow)
+	}
+	if bs.QueueSize < 0 {
+		return bs, nil, fmt.Errorf("invalid queue size: %d", bs.QueueSize)
+	}
+
+	bs.topicWorkers, err = parseTopicWorkers(bs.TopicWorkers)
+	if err != nil {
+		return bs, nil, fmt.Errorf("failed to parse topic workers: %w", err)
+	}
+
+	bs.forwardHeaders, err = parseForwardHeaders(bs.ForwardHeaders)
+	if err != nil {
+		return bs, nil, fmt.Errorf("invalid forwarded header: %w", err)
+	}
+
+	if bs.RetryDelay > 0 {
+		bs.retries = newRetryTracker(bs.RetryDelay, bs.MaxRetryDelay)
+	}
+
+	bs.transforms, err = transforms.Parse(bs.Transforms)
+	if err != nil {
+		return bs, nil, fmt.Errorf("failed to parse transforms: %w", err)
+	}
+
+	if err := bs.Enrichment.validate(); err != nil {
+		return bs, nil, err
+	}
+	if err := bs.Webhook.validate(); err != nil {
+		return bs, nil, err
+	}
+
+	if bs.FeatureSelector != "" {
+		bs.featureSelector, err = labels.Parse(bs.FeatureSelector)
+		if err != nil {
+			return bs, nil, fmt.Errorf("invalid feature selector: %w", err)
+		}
+	}
+
+	if err := bs.DescriptorSet.validate(); err != nil {
+		return bs, nil, err
+	}
+	if bs.DescriptorSet.Path != "" && bs.Schema != nil {
+		return bs, nil, fmt.Errorf("schema and descriptor_set are mutually exclusive")
+	}
+
+	broker := brokers.Get(bs.BrokerKind)
+	if broker == nil {
+		return bs, nil, fmt.Errorf("broker %s not found", bs.BrokerKind)
+	}
+	bs.mdExtractor = broker.Metadata
+
+	return bs, broker, nil
 }
 
 func (m *manager) Add(ctx context.Context, in *raptorApi.DataSource) {
+	m.mu.Lock()
 	m.ready = false
+	m.err = nil
+	m.in = in
+	m.mu.Unlock()
 	if in.Spec.Kind != "streaming" {
 		m.logger.Error(fmt.Errorf("unsupported DataConenctor kind: %s", in.Spec.Kind), "kind is not streaming")
 		return
@@ -129,96 +640,502 @@ func (m *manager) Add(ctx context.Context, in *raptorApi.DataSource) {
 	cfg, err := in.ParseConfig(ctx, m.client)
 	if err != nil {
 		m.logger.Error(err, "failed to retrieve config")
+		m.setErr(fmt.Errorf("failed to retrieve config: %w", err))
+		return
+	}
+	if err := renderConfig(in, cfg); err != nil {
+		m.logger.Error(err, "failed to render config")
+		m.setErr(err)
+		return
 	}
 
-	bs := BaseStreaming{}
-	err = cfg.Unmarshal(&bs)
+	bs, broker, err := parseBaseStreaming(cfg)
 	if err != nil {
-		m.logger.Error(err, "failed to unmarshal streaming config")
+		m.logger.Error(err, "invalid streaming config")
 		return
 	}
-	if bs.Workers == 0 {
-		bs.Workers = 1
+
+	// the controller may not have populated the features of a new DataSource yet, and the status update re-adds it
+	if len(in.Status.Features) == 0 && bs.EmptyFeaturesPolicy == EmptyFeaturesWait {
+		m.logger.Info("DataSource has no features yet, waiting for them")
+		m.setErr(fmt.Errorf("waiting for the DataSource's features"))
+		return
 	}
 
-	if bs.Schema != nil {
-		_, err := protoregistry.Register(bs.Schema.String())
+	bs.dsMetadata, err = dataSourceMetadata(in.Labels, in.Annotations, bs)
+	if err != nil {
+		m.logger.Error(err, "invalid streaming config")
+		return
+	}
+
+	bs.SkipSchemaRegistration = bs.SkipSchemaRegistration || m.skipSchemaRegistration
+	if bs.Schema != nil && !bs.SkipSchemaRegistration {
+		_, err := registerSchema(m.logger, bs.Schema.String())
 		if err != nil {
 			m.logger.Error(err, "failed to register schema")
 			return
 		}
 	}
 
-	broker := brokers.Get(bs.BrokerKind)
-	if broker == nil {
-		m.logger.Error(fmt.Errorf("broker %s not found", bs.BrokerKind), "invalid broker kind")
+	bs.descriptor, err = bs.DescriptorSet.load(ctx)
+	if err != nil {
+		m.logger.Error(err, "failed to load descriptor set")
 		return
 	}
-	bs.mdExtractor = broker.Metadata
 
-	// Spawn a sub context for the broker
-	// This allowing us to replace the broker context with a new one using cancel
-	ctx, cancel := context.WithCancel(context.Background())
+	// Spawn a sub context for the broker, so Update can replace the broker using cancel, while the DataSource's
+	// cancellation (i.e. shutdown) still propagates to it
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	m.mu.Lock()
 	m.cancel = cancel
+	m.mu.Unlock()
+
+	bs.enricher, err = newEnricher(ctx, bs.Enrichment, m.logger.WithName("enricher"))
+	if err != nil {
+		m.logger.Error(err, "failed to setup enrichment")
+		cancel()
+		return
+	}
+
+	bs.notifier = newNotifier(ctx, bs.Webhook, m.logger.WithName("webhook"))
+
+	bs.failures, err = newFailurePublisher(ctx, bs.FailureTopic, m.logger.WithName("failures"))
+	if err != nil {
+		m.logger.Error(err, "failed to setup failure records")
+		cancel()
+		return
+	}
 
 	// Create a new subscription
-	ctx = brokers.ContextWithDataSource(ctx, in)
-	ctx, bs.subscription, err = broker.Subscribe(ctx, cfg)
+	ctx = logr.NewContext(ctx, m.logger.WithValues("broker", bs.BrokerKind))
+	ctx, bs.subscriptions, err = broker.Subscribe(ctx, cfg)
 	if err != nil {
 		m.logger.Error(err, "failed to create subscription")
+		cancel()
 		return
 	}
+	m.shutdowns.Add(1)
 	go func(ctx context.Context) {
+		defer m.shutdowns.Done()
 		<-ctx.Done()
-		err := bs.subscription.Shutdown(context.TODO())
-		if err != nil {
-			m.logger.Error(err, "failed to shutdown streaming")
+
+		// an unresponsive broker mustn't block the termination
+		sctx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+		defer cancel()
+		for _, sub := range bs.subscriptions {
+			err := sub.Shutdown(sctx)
+			if errors.Is(err, context.DeadlineExceeded) {
+				m.logger.Error(err, "abandoned the shutdown of the streaming", "topic", sub.Topic,
+					"timeout", m.shutdownTimeout)
+				continue
+			}
+			if err != nil {
+				m.logger.Error(err, "failed to shutdown streaming", "topic", sub.Topic)
+			}
 		}
-		m.cancel = nil
 	}(ctx)
 
-	bs.features = m.getFeatureDefinitions(ctx, in, bs)
-	m.subscribe(ctx, bs)
-	m.ready = true
+	if err := selfTest(ctx, bs.subscriptions); err != nil {
+		m.logger.Error(err, "broker connectivity self-test failed")
+		m.setErr(fmt.Errorf("broker connectivity self-test failed: %w", err))
+		cancel()
+		return
+	}
+
+	var pending []raptorApi.ResourceReference
+	bs.features, pending = m.getFeatureDefinitions(ctx, in, bs)
+	if len(pending) > 0 {
+		bs.runtimeGate = &runtimeGate{}
+		bs.runtimeGate.pending.Store(int32(len(pending)))
+		go m.loadPending(ctx, pending, bs)
+	}
+	bs.inFlight = &inFlight{}
+	m.mu.Lock()
 	m.bs = &bs
-	m.logger.Info("Listening for streaming events...")
+	m.mu.Unlock()
+
+	// on cold start, let the runtime warm up before receiving
+	if m.warmupDelay > 0 && !m.warmedUp.Swap(true) {
+		m.logger.Info("Warming up...", "delay", m.warmupDelay)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(m.warmupDelay):
+				m.listen(ctx, parent, bs)
+			}
+		}()
+		return
+	}
+	m.listen(ctx, parent, bs)
 }
 
-func (m *manager) Update(ctx context.Context, _ *raptorApi.DataSource, in *raptorApi.DataSource) {
-	if m.cancel != nil {
-		m.cancel()
-		m.bs = nil
+// listen starts receiving the messages, and marks the manager as ready
+func (m *manager) listen(ctx, parent context.Context, bs BaseStreaming) {
+	m.subscribe(ctx, parent, bs)
+	if m.idleTimeout > 0 {
+		go m.watchdog(ctx, parent, bs)
+	}
+	if m.featureRefresh > 0 {
+		go m.refreshFeatures(ctx, bs)
 	}
+	m.mu.Lock()
+	// the subscription might have been replaced meanwhile
+	m.ready = ctx.Err() == nil
+	m.mu.Unlock()
+	m.logger.Info("Listening for streaming events...")
+}
 
+// Update replaces the subscription with the updated DataSource. Informer resyncs redeliver unchanged objects, so
+// when the spec hasn't changed (the generation is the same) and the subscription is healthy, only the features are
+// synced.
+// An unhealthy subscription is replaced regardless, so resyncs retry failed subscriptions.
+func (m *manager) Update(ctx context.Context, old *raptorApi.DataSource, in *raptorApi.DataSource) {
+	m.mu.Lock()
+	healthy := m.ready
+	// labels and annotations changes don't bump the generation, but may change the forwarded metadata
+	if old.Generation == in.Generation && healthy && labels.Equals(old.Labels, in.Labels) &&
+		labels.Equals(old.Annotations, in.Annotations) {
+		// the status (i.e. the features) may still change
+		m.in = in
+		m.mu.Unlock()
+		m.syncFeatures(ctx, in)
+		return
+	}
+	m.mu.Unlock()
+	m.stop()
 	m.Add(ctx, in)
 }
 
-func (m *manager) subscribe(ctx context.Context, bs BaseStreaming) {
-	for i := 0; i < bs.Workers; i++ {
-		go func() {
+// selfTestTimeout is the time to wait for the subscriptions to respond to the connectivity self-test
+const selfTestTimeout = 10 * time.Second
+
+// selfTest verifies the subscriptions are reachable before they're marked as ready
+func selfTest(ctx context.Context, subs []brokers.Subscription) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	for _, sub := range subs {
+		if sub.Ping == nil {
+			continue
+		}
+		if err := sub.Ping(ctx); err != nil {
+			if sub.Topic != "" {
+				return fmt.Errorf("%s: %w", sub.Topic, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// received is a message received from one of the subscriptions
+type received struct {
+	msg      *pubsub.Message
+	sub      brokers.Subscription
+	inFlight *inFlight
+}
+
+// subscribe fans-in the messages of the subscriptions into a shared pool of workers. Subscriptions with dedicated
+// topic workers are consumed by their own pool, so a hot topic can't starve the others.
+func (m *manager) subscribe(ctx, parent context.Context, bs BaseStreaming) {
+	var shared chan received
+	for _, sub := range bs.subscriptions {
+		if n, ok := bs.topicWorkers[sub.Topic]; ok && sub.Topic != "" {
+			msgs := make(chan received, bs.QueueSize)
+			go m.receive(ctx, parent, sub, msgs, sub.Topic, bs.inFlight)
+			m.work(ctx, msgs, n, sub.Topic, bs)
+			continue
+		}
+
+		if shared == nil {
+			shared = make(chan received, bs.QueueSize)
+			m.work(ctx, shared, bs.Workers, sharedPool, bs)
+		}
+		go m.receive(ctx, parent, sub, shared, sharedPool, bs.inFlight)
+	}
+}
+
+// sharedPool is the name of the workers pool shared by the topics without dedicated workers
+const sharedPool = "shared"
+
+// work spawns a pool of workers that process the messages
+func (m *manager) work(ctx context.Context, msgs <-chan received, workers int, pool string, bs BaseStreaming) {
+	if bs.CompactionWindow > 0 {
+		msgs = m.compact(ctx, msgs, bs)
+	}
+	if bs.PriorityHeader != "" {
+		msgs = m.prioritize(ctx, msgs, bs)
+	}
+
+	if bs.KeyAffinity && workers > 1 {
+		queues := make([]chan received, workers)
+		for i := range queues {
+			queues[i] = make(chan received)
+			go m.staggeredWorker(ctx, queues[i], i, pool, bs)
+		}
+		go m.route(ctx, msgs, queues, bs)
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go m.staggeredWorker(ctx, msgs, i, pool, bs)
+	}
+}
+
+// defaultWorkerStagger is the delay between starting the workers, unless configured otherwise
+const defaultWorkerStagger = 10 * time.Millisecond
+
+// staggeredWorker starts the i-th worker after i staggers, jittered, so a large pool doesn't hit the runtime all at
+// once on startup
+func (m *manager) staggeredWorker(ctx context.Context, msgs <-chan received, i int, pool string, bs BaseStreaming) {
+	stagger := bs.WorkerStagger
+	if stagger == 0 {
+		stagger = defaultWorkerStagger
+	}
+	if stagger > 0 {
+		delay := time.Duration(i)*stagger + time.Duration(rand.Int63n(int64(stagger)))
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	m.worker(ctx, msgs, pool, bs)
+}
+
+// worker processes the messages until the context is done.
+// Once the context is done, the queued messages are nacked, so they can be redelivered.
+func (m *manager) worker(ctx context.Context, msgs <-chan received, pool string, bs BaseStreaming) {
+	for {
+		select {
+		case <-ctx.Done():
 			for {
 				select {
-				case <-ctx.Done():
-					return
-				default:
-					msg, err := bs.subscription.Receive(ctx)
-					if err != nil {
-						m.logger.Error(err, "failed to receive message")
-						return
+				case r := <-msgs:
+					if r.msg.Nackable() {
+						r.msg.Nack()
 					}
-					md := bs.mdExtractor(ctx, msg)
-					if err := m.handle(ctx, msg, md, bs); err != nil {
-						if msg.Nackable() {
-							msg.Nack()
-						}
-						m.logger.Error(err, "failed to handle message")
-					}
-
-					msg.Ack()
+				default:
+					return
 				}
 			}
-		}()
+		case r := <-msgs:
+			queueDepth.WithLabelValues(pool).Set(float64(len(msgs)))
+			m.safeProcess(ctx, r, bs)
+			r.inFlight.done()
+		}
+	}
+}
+
+// parseTopicWorkers parses a list of `topic=workers` pairs
+func parseTopicWorkers(pairs []string) (map[string]int, error) {
+	ret := make(map[string]int)
+	for _, p := range pairs {
+		topic, n, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid topic workers `%s`: expected `topic=workers`", p)
+		}
+		workers, err := strconv.Atoi(n)
+		if err != nil || workers < 1 {
+			return nil, fmt.Errorf("invalid number of workers for topic %s: %s", topic, n)
+		}
+		ret[topic] = workers
+	}
+	return ret, nil
+}
+
+// receive pushes the messages of the subscription to the workers.
+// The parent context is the context of the DataSource, used to resubscribe in case of a retryable failure.
+func (m *manager) receive(ctx, parent context.Context, sub brokers.Subscription, msgs chan<- received, pool string,
+	inFlight *inFlight) {
+	for {
+		// hold the messages on the broker while paused
+		if err := m.pauser.wait(ctx); err != nil {
+			return
+		}
+
+		// the receive is counted as in-flight, so draining waits for a message that is being received
+		inFlight.add()
+		rctx, cancel := m.pauser.receiveContext(ctx)
+		msg, err := sub.Receive(rctx)
+		// checked before releasing the receive context, which cancels it
+		paused := rctx.Err() != nil
+		cancel()
+		if err != nil {
+			inFlight.done()
+			if ctx.Err() != nil {
+				return
+			}
+			if paused {
+				continue
+			}
+			m.receiveFailed(ctx, parent, sub, err)
+			return
+		}
+		m.resubscribes.Store(0)
+		m.lastReceive.Store(sub.Topic, time.Now())
+
+		select {
+		case <-ctx.Done():
+			if msg.Nackable() {
+				msg.Nack()
+			}
+			inFlight.done()
+			return
+		case msgs <- received{msg: msg, sub: sub, inFlight: inFlight}:
+			queueDepth.WithLabelValues(pool).Set(float64(len(msgs)))
+		}
+	}
+}
+
+// maxResubscribeBackoff is the maximum time to back off before resubscribing after a retryable receive failure
+const maxResubscribeBackoff = time.Minute
+
+// receiveFailed handles a receive failure. Receive failures are permanent for the subscription, so for retryable
+// failures (i.e. the broker is briefly unreachable) the manager backs off and resubscribes. Failures that indicate
+// a misconfiguration (i.e. the topic doesn't exist) are fatal.
+func (m *manager) receiveFailed(ctx, parent context.Context, sub brokers.Subscription, err error) {
+	code := gcerrors.Code(err)
+	switch code {
+	case gcerrors.NotFound, gcerrors.PermissionDenied, gcerrors.InvalidArgument, gcerrors.FailedPrecondition,
+		gcerrors.Unimplemented:
+		m.logger.Error(err, "fatal failure receiving messages", "topic", sub.Topic, "code", code.String())
+		m.stop()
+		m.setErr(fmt.Errorf("failed to receive messages from %s: %w", sub.Topic, err))
+		return
+	}
+
+	n := m.resubscribes.Add(1)
+	backoff := time.Second << min(n-1, 6)
+	if backoff > maxResubscribeBackoff {
+		backoff = maxResubscribeBackoff
+	}
+	m.logger.Error(err, "failed to receive messages, resubscribing", "topic", sub.Topic, "code", code.String(),
+		"backoff", backoff)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(backoff):
+	}
+	m.resubscribe(ctx, parent)
+}
+
+// resubscribe replaces the subscriptions of the context with new ones
+func (m *manager) resubscribe(ctx, parent context.Context) {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+
+	// another receiver (or an update) might have already replaced the subscriptions
+	if ctx.Err() != nil {
+		return
+	}
+	m.mu.RLock()
+	in := m.in
+	m.mu.RUnlock()
+
+	m.stop()
+	m.Add(parent, in)
+}
+
+func (m *manager) process(ctx context.Context, r received, bs BaseStreaming) {
+	msg := r.msg
+	md := bs.mdExtractor(ctx, msg)
+	if md.Topic == "" {
+		md.Topic = r.sub.Topic
+	}
+	if ts, ok := bs.timestamp(msg.Metadata, md); ok {
+		md.Timestamp = ts
+	} else {
+		timestampFallbacks.WithLabelValues(md.Topic).Inc()
+		md.Timestamp = time.Now()
+		md.TimestampFallback = true
+	}
+	if md.ID == "" {
+		md.ID = newUUID()
+	} else if bs.TopicScopedIDs {
+		md.ID = fmt.Sprintf("%s/%s", md.Topic, md.ID)
+	}
+
+	// the logs of the message are attributable by its id and topic
+	logger := m.logger.WithValues("msgID", md.ID, "topic", md.Topic)
+	ctx = logr.NewContext(ctx, logger)
+
+	if md.Attempts > 0 {
+		redeliveryAttempts.WithLabelValues(md.Topic).Observe(float64(md.Attempts))
+		if bs.RedeliveryWarningThreshold > 0 && md.Attempts >= bs.RedeliveryWarningThreshold {
+			logger.Info("message is being redelivered repeatedly", "attempts", md.Attempts)
+		}
+	}
+
+	stopExtending := m.extendAckDeadline(ctx, r, bs)
+	fqns, err := m.handle(ctx, msg, md, bs)
+	stopExtending()
+	if bs.notifier != nil {
+		bs.notifier.notify(md, fqns, err)
+	}
+	if err != nil {
+		if bs.failures != nil {
+			bs.failures.publish(md, err)
+		}
+
+		var fe *featureError
+		if errors.As(err, &fe) {
+			logger = logger.WithValues("fqn", fe.FQN)
+		}
+
+		// redelivering the message won't help, so it's dropped
+		if isPermanent(err) {
+			var ee *encodingError
+			if errors.As(err, &ee) {
+				logger.Error(err, "failed to decode message, dropping it")
+				encodingErrors.WithLabelValues(md.Topic).Inc()
+			} else {
+				logger.Error(err, "failed to handle message permanently, dropping it")
+				permanentFailures.WithLabelValues(md.Topic).Inc()
+			}
+			if bs.retries != nil {
+				bs.retries.Succeeded(md.ID)
+			}
+			msg.Ack()
+			return
+		}
+
+		logger.Error(err, "failed to handle message")
+		m.nack(ctx, r, md, bs)
+
+		// hold while the runtime is unavailable, to avoid hammering it
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, ErrRuntimeUnavailable) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(breakerHoldInterval):
+			}
+		}
+		return
+	}
+
+	if bs.retries != nil {
+		bs.retries.Succeeded(md.ID)
+	}
+	msg.Ack()
+}
+
+// nack releases a failed message for redelivery, applying the retry delay when the broker supports it
+func (m *manager) nack(ctx context.Context, r received, md brokers.Metadata, bs BaseStreaming) {
+	msg := r.msg
+	if !msg.Nackable() {
+		msg.Ack()
+		return
+	}
+
+	if bs.retries != nil && r.sub.NackWithDelay != nil {
+		delay := bs.retries.Failed(md.ID)
+		if err := r.sub.NackWithDelay(ctx, msg, delay); err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "failed to delay message redelivery", "delay", delay)
+		}
+		return
 	}
+	msg.Nack()
 }
 
 func newUUID() string {
@@ -0,0 +1,287 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/raptor-ml/raptor/api"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandleExecutesAndAcks(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	ft := h.addFeature(testFeature("clicks", ""))
+	h.start()
+
+	h.send("events", `{"id": "user-1", "page": "home"}`, "id", "msg-1")
+
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "the feature wasn't executed")
+	exec := h.rt.executions(ft.FQN)[0]
+	if exec.Keys["id"] != "user-1" {
+		t.Errorf("expected the keys to be taken from the message, got %v", exec.Keys)
+	}
+	if exec.Row["page"] != "home" {
+		t.Errorf("expected the row to be the message, got %v", exec.Row)
+	}
+	// a nacked message would be redelivered and executed again
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "the message was redelivered")
+}
+
+func TestProgramReloadedAfterNotFound(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	h.m.runtimeManager = WithProgramCache(h.rt, 10)
+	ftSpec := testFeature("clicks", "")
+	ft := h.addFeature(ftSpec)
+
+	var failed atomic.Bool
+	h.rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		// the runtime restarted, and lost the program
+		if !failed.Swap(true) {
+			return status.Error(codes.NotFound, "program not found")
+		}
+		return nil
+	}
+	h.start()
+	h.send("events", `{"id": "user-1"}`, "id", "msg-1")
+
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 2 }, "the failed message wasn't redelivered")
+	if err := h.rt.executions(ft.FQN)[1].Err; err != nil {
+		t.Fatalf("expected the redelivered message to succeed, got %s", err)
+	}
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 2 }, "the message was redelivered again")
+
+	// the program was evicted from the cache, so reloading the feature registers it again
+	if _, err := h.m.getFeature(context.Background(), ftSpec.ResourceReference(), h.bs); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.rt.loaded(ft.FQN); n != 2 {
+		t.Errorf("expected the program to be registered again, got %d loads", n)
+	}
+}
+
+func TestAgeNotObservedWithoutTimestamp(t *testing.T) {
+	h := newHarness(t, nil, "untimed")
+	ft := h.addFeature(testFeature("clicks", ""))
+	h.start()
+
+	// the in-memory broker doesn't provide timestamps, so the processing time is used instead
+	h.send("untimed", `{"id": "user-1"}`, "id", "msg-1")
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "the feature wasn't executed")
+
+	if !messageBytes.DeleteLabelValues("untimed") {
+		t.Fatal("expected the message to be observed")
+	}
+	if messageAge.DeleteLabelValues("untimed") {
+		t.Error("expected the age of a message without a timestamp not to be observed")
+	}
+}
+
+func TestResubscribeWithSchema(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	var subscribed atomic.Bool
+	h.newSubscription = func(topic string) *pubsub.Subscription {
+		// the first subscription fails, as when the broker is briefly unreachable (gRPC Unavailable maps to Unknown)
+		if !subscribed.Swap(true) {
+			return pubsub.NewSubscription(&fakeSubscription{code: gcerrors.Unknown}, nil, nil)
+		}
+		return mempubsub.NewSubscription(h.topics[topic], time.Minute)
+	}
+
+	h.m.Add(h.ctx, dataSource(map[string]string{"schema": serveSchema(t, "resubscribe")}))
+	h.eventually(func() bool { return h.subscribes.Load() == 2 }, "the DataSource wasn't resubscribed")
+	h.eventually(func() bool { return h.m.Ready(h.ctx) }, "the resubscribed DataSource isn't ready")
+	if err := h.m.Err(); err != nil {
+		t.Errorf("expected the schema to be registered again, got %s", err)
+	}
+}
+
+func TestReceiveFailed(t *testing.T) {
+	tests := []struct {
+		code  gcerrors.ErrorCode
+		fatal bool
+	}{
+		{gcerrors.NotFound, true},
+		{gcerrors.PermissionDenied, true},
+		{gcerrors.InvalidArgument, true},
+		{gcerrors.FailedPrecondition, true},
+		{gcerrors.Unimplemented, true},
+		// gRPC's Unavailable maps to Unknown
+		{gcerrors.Unknown, false},
+		{gcerrors.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			h := newHarness(t, nil, "events")
+			h.m.in = dataSource(nil)
+			sub := pubsub.NewSubscription(&fakeSubscription{code: tt.code}, nil, nil)
+			_, err := sub.Receive(context.Background())
+			if gcerrors.Code(err) != tt.code {
+				t.Fatalf("expected a receive error of code %s, got %v", tt.code, err)
+			}
+
+			ctx, cancel := context.WithCancel(h.ctx)
+			defer cancel()
+			h.m.receiveFailed(ctx, h.ctx, brokers.Subscription{Subscription: sub, Topic: "events"}, err)
+			if tt.fatal {
+				if h.m.Err() == nil {
+					t.Error("expected the failure to set the failed condition")
+				}
+				if n := h.subscribes.Load(); n != 0 {
+					t.Errorf("expected a fatal failure not to resubscribe, got %d subscribes", n)
+				}
+				return
+			}
+			if err := h.m.Err(); err != nil {
+				t.Errorf("expected a retryable failure not to set the failed condition, got %s", err)
+			}
+			if n := h.subscribes.Load(); n != 1 {
+				t.Errorf("expected a retryable failure to resubscribe, got %d subscribes", n)
+			}
+			if n := h.m.resubscribes.Load(); n != 1 {
+				t.Errorf("expected a retryable failure to back off, got %d resubscribes", n)
+			}
+		})
+	}
+}
+
+func TestResyncDoesntResubscribe(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	in := dataSource(nil)
+	h.m.Add(h.ctx, in)
+
+	// informer resyncs redeliver the unchanged DataSource
+	h.m.Update(h.ctx, in, in.DeepCopy())
+	h.m.Update(h.ctx, in, in.DeepCopy())
+	if n := h.subscribes.Load(); n != 1 {
+		t.Fatalf("expected the resyncs not to resubscribe, got %d subscribes", n)
+	}
+
+	changed := in.DeepCopy()
+	changed.Generation++
+	h.m.Update(h.ctx, in, changed)
+	if n := h.subscribes.Load(); n != 2 {
+		t.Errorf("expected a spec change to resubscribe, got %d subscribes", n)
+	}
+	if !h.m.Ready(h.ctx) {
+		t.Error("expected the resubscribed manager to be ready")
+	}
+}
+
+func TestStartShutdownIsBounded(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	timeout := 200 * time.Millisecond
+	WithShutdownTimeout(timeout)(h.m)
+	// the broker never completes the shutdown
+	closing := make(chan struct{})
+	t.Cleanup(func() { close(closing) })
+	h.newSubscription = func(string) *pubsub.Subscription {
+		return pubsub.NewSubscription(&fakeSubscription{closing: closing}, nil, nil)
+	}
+	h.m.Add(h.ctx, dataSource(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- h.m.Start(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < timeout {
+			t.Errorf("expected Start to wait for the shutdown for %s, returned after %s", timeout, elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Start to return once the shutdown timed out")
+	}
+}
+
+func TestFullQueueBlocksReceivers(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	for i := 0; i < 5; i++ {
+		h.send("events", fmt.Sprintf(`{"id": "user-%d"}`, i), "id", fmt.Sprintf("msg-%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// there are no workers, so the receiver fills the queue and holds the next message
+	const pool = "backpressure"
+	msgs := make(chan received, 2)
+	go h.m.receive(ctx, ctx, h.bs.subscriptions[0], msgs, pool, h.bs.inFlight)
+	depth := func() float64 {
+		return testutil.ToFloat64(queueDepth.WithLabelValues(pool))
+	}
+	blocked := func() bool {
+		return len(msgs) == 2 && depth() == 2 && h.bs.inFlight.n.Load() == 3
+	}
+	h.eventually(blocked, "expected the receiver to fill the queue")
+	h.consistently(blocked, "expected the receiver to stop receiving while the queue is full")
+
+	// a worker makes room for the held message only
+	r := <-msgs
+	r.msg.Ack()
+	r.inFlight.done()
+	h.eventually(blocked, "expected the receiver to queue the held message")
+
+	h.m.work(ctx, msgs, 1, pool, h.bs)
+	h.eventually(func() bool { return len(msgs) == 0 && depth() == 0 }, "expected the workers to empty the queue")
+}
+
+func TestSubscriptionsShareThePool(t *testing.T) {
+	h := newHarness(t, raptorApi.ParsedConfig{"workers": "1"}, "fanin-orders", "fanin-refunds")
+	all := h.addFeature(testFeature("all", ""))
+	orders := h.addFeature(testFeature("orders", `{"topics": ["fanin-orders"]}`))
+	refunds := h.addFeature(testFeature("refunds", `{"topics": ["fanin-refunds"]}`))
+	h.start()
+
+	h.send("fanin-orders", `{"id": "order"}`, "id", "msg-1")
+	h.send("fanin-refunds", `{"id": "refund"}`, "id", "msg-2")
+	h.eventually(func() bool { return len(h.rt.executions(all.FQN)) == 2 }, "the messages of both topics weren't handled")
+	h.eventually(func() bool {
+		return len(h.rt.executions(orders.FQN)) == 1 && len(h.rt.executions(refunds.FQN)) == 1
+	}, "the topic features weren't executed")
+
+	// the messages are attributed to the topic of their subscription
+	if id := h.rt.executions(orders.FQN)[0].Keys["id"]; id != "order" {
+		t.Errorf("expected the orders feature to see the orders topic only, got %s", id)
+	}
+	if id := h.rt.executions(refunds.FQN)[0].Keys["id"]; id != "refund" {
+		t.Errorf("expected the refunds feature to see the refunds topic only, got %s", id)
+	}
+	for _, topic := range h.names {
+		if !messageBytes.DeleteLabelValues(topic) {
+			t.Errorf("expected a message of %s to be observed", topic)
+		}
+	}
+	h.consistently(func() bool {
+		return len(h.rt.executions(orders.FQN)) == 1 && len(h.rt.executions(refunds.FQN)) == 1
+	}, "a topic feature saw the message of another topic")
+}
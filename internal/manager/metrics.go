@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+)
+
+var (
+	breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "streaming_runner_runtime_circuit_breaker_state",
+		Help: "The state of the runtime circuit breaker (0 - closed, 1 - half-open, 2 - open)",
+	})
+	sampledOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_sampled_out_total",
+		Help: "Number of feature executions skipped by the feature's sample rate or entity sample rate",
+	}, []string{"fqn"})
+	messageAge = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_message_age_seconds",
+		Help:    "The age of the messages (from their broker timestamp) when they're being handled",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"topic"})
+	messageBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_message_bytes",
+		Help:    "The size of the message bodies, as received from the broker",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"topic"})
+	decompressedMessageBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_decompressed_message_bytes",
+		Help:    "The size of the compressed message bodies, after decompressing them",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"topic"})
+	paused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "streaming_runner_paused",
+		Help: "Whether receiving messages is paused (1) or not (0)",
+	})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "streaming_runner_queue_depth",
+		Help: "Number of received messages waiting for a worker",
+	}, []string{"pool"})
+	failureRecordsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "streaming_runner_failure_records_dropped_total",
+		Help: "Number of failure records dropped since the failure topic couldn't keep up",
+	})
+	encodingErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_encoding_errors_total",
+		Help: "Number of malformed messages that were dropped since they couldn't be decoded",
+	}, []string{"topic"})
+	permanentFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_permanent_failures_total",
+		Help: "Number of messages that were dropped since they failed permanently (other than encoding errors)",
+	}, []string{"topic"})
+	timestampFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_timestamp_fallbacks_total",
+		Help: "Number of messages without a resolvable timestamp, that were timestamped with the time they're handled",
+	}, []string{"topic"})
+	tombstones = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_tombstones_total",
+		Help: "Number of messages without a body, by whether the body is null or empty",
+	}, []string{"topic", "kind"})
+	concurrentExecutions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "streaming_runner_concurrent_executions",
+		Help: "Number of programs being executed concurrently, when the concurrency is limited",
+	})
+	runtimeMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "streaming_runner_runtime_uuid_mismatches_total",
+		Help: "Number of runtime responses that didn't match their request's uuid, and were retried",
+	})
+	redeliveryAttempts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_redelivery_attempts",
+		Help:    "The delivery attempt of the handled messages, for brokers tracking it",
+		Buckets: []float64{1, 2, 3, 5, 10, 20, 50},
+	}, []string{"topic"})
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "streaming_runner_build_info",
+		Help: "The build of the runner, as labels. The value is always 1",
+	}, []string{"version", "commit", "goversion"})
+	compacted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_compacted_messages_total",
+		Help: "Number of messages acked unhandled since a later message of their key superseded them",
+	}, []string{"topic"})
+	webhookDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "streaming_runner_webhook_notifications_dropped_total",
+		Help: "Number of webhook notifications dropped since the webhook couldn't keep up",
+	})
+	programCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "streaming_runner_program_cache_size",
+		Help: "Number of programs in the program cache",
+	})
+	programCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_program_cache_lookups_total",
+		Help: "Number of program loads looked up in the program cache, by result (hit or miss)",
+	}, []string{"result"})
+	idleResubscribes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_idle_resubscribes_total",
+		Help: "Number of resubscriptions by the idle watchdog, since no message was received for too long",
+	}, []string{"topic"})
+	featuresDisabled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_features_disabled_total",
+		Help: "Number of times a feature was disabled since it kept failing",
+	}, []string{"fqn"})
+	disabledSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_disabled_feature_skips_total",
+		Help: "Number of feature executions skipped since the feature is disabled",
+	}, []string{"fqn"})
+	panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "streaming_runner_panics_total",
+		Help: "Number of panics recovered while processing messages",
+	})
+	registrationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_registration_duration_seconds",
+		Help:    "The duration of the schema and program registrations, by kind (schema or program)",
+		Buckets: prometheus.ExponentialBuckets(0.01, 3, 8),
+	}, []string{"kind"})
+	schemaCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_schema_cache_lookups_total",
+		Help: "Number of schema registrations looked up in the schema cache, by result (hit or miss)",
+	}, []string{"result"})
+	featureExecutions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "streaming_runner_feature_executions_total",
+		Help: "Number of program executions, by feature and result (success or failure)",
+	}, []string{"fqn", "result"})
+	featureExecutionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "streaming_runner_feature_execution_duration_seconds",
+		Help:    "The duration of the program executions, by feature",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"fqn"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(breakerState, sampledOut, messageAge, paused, queueDepth,
+		failureRecordsDropped, encodingErrors, timestampFallbacks,
+		tombstones, concurrentExecutions, runtimeMismatches,
+		redeliveryAttempts, buildInfo, featureExecutions, featureExecutionDuration,
+		compacted, webhookDropped, permanentFailures,
+		programCacheSize, programCacheLookups, idleResubscribes,
+		featuresDisabled, disabledSkips, panics,
+		registrationDuration, schemaCacheLookups, messageBytes, decompressedMessageBytes)
+}
+
+// OtherFeaturesLabel is the fqn label of the features beyond the feature labels limit
+const OtherFeaturesLabel = "other"
+
+// DefaultFeatureLabelsLimit is the default number of distinct features labeled in the metrics
+const DefaultFeatureLabelsLimit = 100
+
+// featureLabels bounds the cardinality of the metrics labeled by fqn. The first features to be labeled keep their
+// fqn, and the rest are collapsed into OtherFeaturesLabel.
+var featureLabels = &featureLabeler{limit: DefaultFeatureLabelsLimit, seen: make(map[string]struct{})}
+
+// LimitFeatureLabels limits the metrics to `limit` distinct fqn labels. Set to `0` to collapse all the features into
+// OtherFeaturesLabel (i.e. to disable the feature labels).
+func LimitFeatureLabels(limit int) {
+	featureLabels.mu.Lock()
+	defer featureLabels.mu.Unlock()
+	featureLabels.limit = limit
+}
+
+type featureLabeler struct {
+	mu    sync.RWMutex
+	limit int
+	seen  map[string]struct{}
+}
+
+// label returns the metrics label of the fqn
+func (l *featureLabeler) label(fqn string) string {
+	l.mu.RLock()
+	_, ok := l.seen[fqn]
+	l.mu.RUnlock()
+	if ok {
+		return fqn
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[fqn]; ok {
+		return fqn
+	}
+	if len(l.seen) >= l.limit {
+		return OtherFeaturesLabel
+	}
+	l.seen[fqn] = struct{}{}
+	return fqn
+}
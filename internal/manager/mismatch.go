@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"github.com/raptor-ml/raptor/api"
+	"strings"
+	"time"
+)
+
+type mismatchRuntime struct {
+	api.RuntimeManager
+	retries int
+}
+
+// WithMismatchRetry wraps the RuntimeManager so responses that don't match their request's uuid are retried up to
+// `retries` times. A mismatch indicates a transport anomaly (i.e. a proxy reordering responses), rather than a
+// problem with the message.
+func WithMismatchRetry(rm api.RuntimeManager, retries int) api.RuntimeManager {
+	if retries <= 0 {
+		return rm
+	}
+	return &mismatchRuntime{RuntimeManager: rm, retries: retries}
+}
+
+// mismatchMessage is the error of raptor's RuntimeManager (pkg/runtimemanager) LoadProgram and ExecuteProgram when
+// the response's uuid doesn't match the request's. The error is untyped, so it's matched by its message, which must be
+// kept in sync when upgrading raptor.
+const mismatchMessage = "uuid mismatch"
+
+// isMismatch reports whether the error is a response uuid mismatch, which the runtime manager reports untyped
+func isMismatch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), mismatchMessage)
+}
+
+func (r *mismatchRuntime) LoadProgram(env, fqn, program string, packages []string) (*api.ParsedProgram, error) {
+	for attempt := 0; ; attempt++ {
+		ret, err := r.RuntimeManager.LoadProgram(env, fqn, program, packages)
+		if !isMismatch(err) || attempt == r.retries {
+			return ret, err
+		}
+		runtimeMismatches.Inc()
+	}
+}
+
+func (r *mismatchRuntime) ExecuteProgram(ctx context.Context, env string, fqn string, keys api.Keys, row map[string]any, ts time.Time, dryRun bool) (api.Value, api.Keys, error) {
+	for attempt := 0; ; attempt++ {
+		val, retKeys, err := r.RuntimeManager.ExecuteProgram(ctx, env, fqn, keys, row, ts, dryRun)
+		if !isMismatch(err) || attempt == r.retries || ctx.Err() != nil {
+			return val, retKeys, err
+		}
+		runtimeMismatches.Inc()
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/raptor/api"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMismatchIsRetried(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	h.m.runtimeManager = WithMismatchRetry(h.rt, 2)
+	ft := h.addFeature(testFeature("clicks", ""))
+
+	// the first response is of another request, as raptor's RuntimeManager reports it
+	var mismatched atomic.Bool
+	h.rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		if !mismatched.Swap(true) {
+			return fmt.Errorf("uuid mismatch")
+		}
+		return nil
+	}
+	h.start()
+	h.send("events", `{"id": "user-1"}`, "id", "msg-1")
+
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 2 }, "the mismatch wasn't retried")
+	if err := h.rt.executions(ft.FQN)[1].Err; err != nil {
+		t.Fatalf("expected the retry to succeed, got %s", err)
+	}
+	// the message succeeded on the retry, so it's not redelivered
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 2 }, "the message was redelivered")
+}
+
+func TestMismatchRetriesAreBounded(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.execute = func(context.Context, string, api.Keys, map[string]any) error {
+		return fmt.Errorf("uuid mismatch")
+	}
+	_, _, err := WithMismatchRetry(rt, 2).ExecuteProgram(context.Background(), "default", "clicks", nil, nil,
+		time.Now(), false)
+	if !isMismatch(err) {
+		t.Fatalf("expected the last mismatch to be returned, got %v", err)
+	}
+	if n := len(rt.executions("clicks")); n != 3 {
+		t.Errorf("expected 1 attempt and 2 retries, got %d executions", n)
+	}
+}
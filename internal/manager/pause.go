@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"sync"
+)
+
+// pauser holds the receivers while paused, without closing the subscriptions
+type pauser struct {
+	mu      sync.Mutex
+	resumed chan struct{} // nil when not paused
+
+	// receiving cancels the receives in progress, so a receiver blocked on the broker doesn't take a message after
+	// pausing
+	receiving map[uint64]context.CancelFunc
+	next      uint64
+}
+
+func (p *pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+		paused.Set(1)
+		for _, cancel := range p.receiving {
+			cancel()
+		}
+	}
+}
+
+func (p *pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+		paused.Set(0)
+	}
+}
+
+func (p *pauser) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// wait blocks until resumed, or until the context is done
+func (p *pauser) wait(ctx context.Context) error {
+	p.mu.Lock()
+	ch := p.resumed
+	p.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+// receiveContext returns the context of a single receive, which is canceled when paused
+func (p *pauser) receiveContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		cancel()
+		return ctx, cancel
+	}
+
+	if p.receiving == nil {
+		p.receiving = make(map[uint64]context.CancelFunc)
+	}
+	p.next++
+	id := p.next
+	p.receiving[id] = cancel
+	return ctx, func() {
+		p.mu.Lock()
+		delete(p.receiving, id)
+		p.mu.Unlock()
+		cancel()
+	}
+}
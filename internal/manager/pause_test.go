@@ -0,0 +1,44 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"testing"
+)
+
+func TestPauseHoldsMessages(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	ft := h.addFeature(testFeature("clicks", ""))
+	h.start()
+	h.send("events", `{"id": "user-1"}`, "id", "msg-1")
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "the feature wasn't executed")
+
+	h.m.Pause()
+	if h.m.Ready(h.ctx) {
+		t.Error("expected the paused manager not to be ready")
+	}
+	h.send("events", `{"id": "user-2"}`, "id", "msg-2")
+	h.consistently(func() bool { return len(h.rt.executions(ft.FQN)) == 1 }, "a message was consumed while paused")
+
+	if err := h.m.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	h.eventually(func() bool { return len(h.rt.executions(ft.FQN)) == 2 }, "the held message wasn't consumed")
+	if !h.m.Ready(h.ctx) {
+		t.Error("expected the resumed manager to be ready")
+	}
+}
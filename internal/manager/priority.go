@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+)
+
+// defaultPriorityBuffer is the number of messages reordered by priority, unless the queue size is set
+const defaultPriorityBuffer = 100
+
+type prioritized struct {
+	r        received
+	priority int
+	seq      uint64
+}
+
+// priorityQueue is a max-heap of messages by priority, and then by arrival
+type priorityQueue []prioritized
+
+func (q priorityQueue) Len() int { return len(q) }
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *priorityQueue) Push(x any)   { *q = append(*q, x.(prioritized)) }
+func (q *priorityQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// prioritize buffers the messages, and forwards them to the workers by the priority of their `PriorityHeader`
+// (higher first, defaulting to 0), and then by arrival. It only reorders the buffered messages, so a high priority
+// message still waits for the messages the broker delivers before it, beyond the buffer.
+func (m *manager) prioritize(ctx context.Context, msgs <-chan received, bs BaseStreaming) <-chan received {
+	size := bs.QueueSize
+	if size <= 0 {
+		size = defaultPriorityBuffer
+	}
+
+	out := make(chan received)
+	go func() {
+		var q priorityQueue
+		var seq uint64
+		for {
+			in := msgs
+			if q.Len() >= size {
+				in = nil
+			}
+			var send chan received
+			var next received
+			if q.Len() > 0 {
+				send = out
+				next = q[0].r
+			}
+
+			select {
+			case <-ctx.Done():
+				for _, p := range q {
+					if p.r.msg.Nackable() {
+						p.r.msg.Nack()
+					}
+				}
+				return
+			case r := <-in:
+				priority, _ := strconv.Atoi(r.msg.Metadata[bs.PriorityHeader])
+				heap.Push(&q, prioritized{r: r, priority: priority, seq: seq})
+				seq++
+			case send <- next:
+				heap.Pop(&q)
+			}
+		}
+	}()
+	return out
+}
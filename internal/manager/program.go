@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/url"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// maxProgramSize bounds the size of a program loaded from a URL
+const maxProgramSize = 4 << 20
+
+// programChecksum returns the checksum of the program, used to detect changes
+func programChecksum(program string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(program)))
+}
+
+// validProgramURL checks the program url is an HTTP(S) URL or a ConfigMap key (`configmap://name/key`)
+func validProgramURL(u *url.URL) bool {
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "configmap":
+		return u.Host != "" && strings.Trim(u.Path, "/") != ""
+	}
+	return false
+}
+
+// configMapKey returns the ConfigMap and the key the program is loaded from, if any
+func (ft *Feature) configMapKey() (client.ObjectKey, string, bool) {
+	u, err := url.Parse(ft.ProgramURL)
+	if err != nil || u.Scheme != "configmap" {
+		return client.ObjectKey{}, "", false
+	}
+	return client.ObjectKey{Namespace: ft.ref.Namespace, Name: u.Host}, strings.Trim(u.Path, "/"), true
+}
+
+// program returns the feature's program, either inlined in the Feature or loaded from the program url
+func (m *manager) program(ctx context.Context, ft *Feature, inline string) (string, error) {
+	if ft.ProgramURL == "" {
+		return inline, nil
+	}
+
+	if cm, key, ok := ft.configMapKey(); ok {
+		configMap := &corev1.ConfigMap{}
+		if err := m.client.Get(ctx, cm, configMap); err != nil {
+			return "", fmt.Errorf("failed to get program ConfigMap %s: %w", cm.Name, err)
+		}
+		program, ok := configMap.Data[key]
+		if !ok {
+			return "", fmt.Errorf("ConfigMap %s does not have key %s", cm.Name, key)
+		}
+		return program, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ft.ProgramURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch program: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch program: unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxProgramSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch program: %w", err)
+	}
+	if len(b) > maxProgramSize {
+		return "", fmt.Errorf("program exceeds %d bytes", maxProgramSize)
+	}
+	return string(b), nil
+}
+
+// configMapChanged reloads the features whose program is loaded from the ConfigMap, if their program changed
+func (m *manager) configMapChanged(ctx context.Context, configMap *corev1.ConfigMap) {
+	m.mu.RLock()
+	bs := m.bs
+	m.mu.RUnlock()
+	if bs == nil || bs.features == nil {
+		return
+	}
+
+	for _, ft := range bs.features.List() {
+		cm, key, ok := ft.configMapKey()
+		if !ok || cm != client.ObjectKeyFromObject(configMap) {
+			continue
+		}
+		if program, ok := configMap.Data[key]; ok && programChecksum(program) == ft.sha1 {
+			continue
+		}
+
+		logger := m.logger.WithValues("feature", ft.ref.Name)
+		nft, err := m.getFeature(ctx, ft.ref, *bs)
+		if err != nil {
+			logger.Error(err, "failed to reload feature program; keeping the previous program")
+			continue
+		}
+		bs.features.Set(ft.ref.ObjectKey(), nft)
+		logger.Info("feature program reloaded", "configMap", configMap.Name)
+	}
+}
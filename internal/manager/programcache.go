@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"container/list"
+	"context"
+	"github.com/raptor-ml/raptor/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cachedProgram struct {
+	key    string
+	sum    string
+	parsed *api.ParsedProgram
+}
+
+type programCache struct {
+	api.RuntimeManager
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// WithProgramCache wraps the RuntimeManager so loading a program that is already loaded, unchanged, is skipped
+// (i.e. when resubscribing). The cache holds up to `size` programs, evicting the least recently loaded. The runtime
+// doesn't support unloading programs, so evicted programs are only reloaded the next time they're loaded.
+// A program the runtime reports as not found (i.e. after the runtime restarted) is evicted.
+func WithProgramCache(rm api.RuntimeManager, size int) api.RuntimeManager {
+	if size <= 0 {
+		return rm
+	}
+	return &programCache{RuntimeManager: rm, size: size, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *programCache) LoadProgram(env, fqn, program string, packages []string) (*api.ParsedProgram, error) {
+	key := env + "/" + fqn
+	sum := programChecksum(program + "\x00" + strings.Join(packages, ","))
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && e.Value.(*cachedProgram).sum == sum {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		programCacheLookups.WithLabelValues("hit").Inc()
+		return e.Value.(*cachedProgram).parsed, nil
+	}
+	c.mu.Unlock()
+	programCacheLookups.WithLabelValues("miss").Inc()
+
+	parsed, err := c.RuntimeManager.LoadProgram(env, fqn, program, packages)
+	if err != nil {
+		c.evict(key)
+		return parsed, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cachedProgram{key: key, sum: sum, parsed: parsed})
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cachedProgram).key)
+	}
+	programCacheSize.Set(float64(c.lru.Len()))
+	return parsed, nil
+}
+
+func (c *programCache) ExecuteProgram(ctx context.Context, env string, fqn string, keys api.Keys, row map[string]any, ts time.Time, dryRun bool) (api.Value, api.Keys, error) {
+	val, retKeys, err := c.RuntimeManager.ExecuteProgram(ctx, env, fqn, keys, row, ts, dryRun)
+	if status.Code(err) == codes.NotFound {
+		c.evict(env + "/" + fqn)
+	}
+	return val, retKeys, err
+}
+
+func (c *programCache) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		programCacheSize.Set(float64(c.lru.Len()))
+	}
+}
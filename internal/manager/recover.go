@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"runtime/debug"
+)
+
+// safeProcess processes the message, recovering from a panic (i.e. of a broker driver or a transform) so it doesn't
+// crash the runner. The panicking message is nacked for redelivery, and recorded as a failure.
+func (m *manager) safeProcess(ctx context.Context, r received, bs BaseStreaming) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.recovered(r, bs, p)
+		}
+	}()
+	m.process(ctx, r, bs)
+}
+
+// safeMetadata extracts the metadata of the message before it's processed (i.e. for routing), recovering from a
+// panic of the metadata extractor like safeProcess. The panicking message is settled, and shouldn't be forwarded.
+func (m *manager) safeMetadata(ctx context.Context, r received, bs BaseStreaming) (md brokers.Metadata, ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.recovered(r, bs, p)
+			r.inFlight.done()
+			ok = false
+		}
+	}()
+	return bs.mdExtractor(ctx, r.msg), true
+}
+
+// recovered handles a panic while processing the message: it's nacked for redelivery, and recorded as a failure
+func (m *manager) recovered(r received, bs BaseStreaming, p any) {
+	panics.Inc()
+	err := fmt.Errorf("panic: %v", p)
+	m.logger.Error(err, "recovered from a panic while processing a message", "topic", r.sub.Topic,
+		"msgID", r.msg.LoggableID, "stack", string(debug.Stack()))
+
+	// the metadata extractor may be the one panicking, so the failure record is built from the raw message
+	if bs.failures != nil {
+		bs.failures.publish(brokers.Metadata{Topic: r.sub.Topic, ID: r.msg.LoggableID}, err)
+	}
+
+	// the message may have been acked before the panic, in which case nacking it panics again
+	defer func() {
+		_ = recover()
+	}()
+	if r.msg.Nackable() {
+		r.msg.Nack()
+	} else {
+		r.msg.Ack()
+	}
+}
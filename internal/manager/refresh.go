@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"time"
+)
+
+// refreshFeatures periodically reconciles the features with the current DataSource and Features, in case an event
+// was missed: new features are loaded, removed ones are dropped, and changed ones are reloaded, without
+// resubscribing.
+func (m *manager) refreshFeatures(ctx context.Context, bs BaseStreaming) {
+	ticker := time.NewTicker(m.featureRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		in := &raptorApi.DataSource{}
+		if err := m.client.Get(ctx, m.src, in); err != nil {
+			m.logger.Error(err, "failed to refresh the DataSource's features")
+			continue
+		}
+
+		m.lifecycle.Lock()
+		if ctx.Err() != nil {
+			m.lifecycle.Unlock()
+			return
+		}
+		// spec changes resubscribe through the informer, so only the status is taken
+		m.mu.Lock()
+		if m.in != nil && m.in.Generation == in.Generation {
+			m.in = in
+		}
+		m.mu.Unlock()
+		m.syncFeatures(ctx, in)
+		for _, ft := range bs.features.List() {
+			ftSpec := &raptorApi.Feature{}
+			if err := m.client.Get(ctx, ft.ref.ObjectKey(), ftSpec); err != nil {
+				m.logger.Error(err, "failed to refresh feature", "feature", ft.ref.Name)
+				continue
+			}
+			if ftSpec.Generation != ft.generation {
+				m.reloadFeature(ctx, ftSpec, true)
+			}
+		}
+		m.lifecycle.Unlock()
+	}
+}
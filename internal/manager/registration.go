@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"errors"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/raptor/pkg/protoregistry"
+	"sync"
+	"time"
+)
+
+const (
+	registrationSchema  = "schema"
+	registrationProgram = "program"
+)
+
+// observeRegistration records the duration of a schema or program registration, to tell which are slow to register
+// (i.e. when tuning the warmup delay)
+func observeRegistration(logger logr.Logger, kind, name string, start time.Time) {
+	d := time.Since(start)
+	registrationDuration.WithLabelValues(kind).Observe(d.Seconds())
+	logger.V(1).Info("registered", "kind", kind, "name", name, "duration", d)
+}
+
+// registerSchema registers the schema in the proto registry, recording the registration's duration.
+// Schemas registered within the schema cache's TTL are not registered again. A schema that is already registered
+// (i.e. when the DataSource is added again) is not a failure.
+func registerSchema(logger logr.Logger, schema string) (string, error) {
+	if pack, ok := schemas.get(schema); ok {
+		schemaCacheLookups.WithLabelValues("hit").Inc()
+		return pack, nil
+	}
+	if schemas.enabled() {
+		schemaCacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	defer observeRegistration(logger, registrationSchema, schema, time.Now())
+	pack, err := protoregistry.Register(schema)
+	if err != nil && !errors.Is(err, protoregistry.ErrAlreadyRegistered) {
+		return pack, err
+	}
+	schemas.set(schema, pack)
+	return pack, nil
+}
+
+// schemas caches the registered schemas by their URL, so features sharing a schema register it once
+var schemas = &schemaCache{entries: make(map[string]cachedSchema)}
+
+// CacheSchemas caches the registered schemas for `ttl`, skipping their registration until they expire.
+// Set to `0` to disable the cache.
+func CacheSchemas(ttl time.Duration) {
+	schemas.mu.Lock()
+	defer schemas.mu.Unlock()
+	schemas.ttl = ttl
+	clear(schemas.entries)
+}
+
+type cachedSchema struct {
+	pack    string
+	expires time.Time
+}
+
+type schemaCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedSchema
+}
+
+func (c *schemaCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ttl > 0
+}
+
+func (c *schemaCache) get(schema string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[schema]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, schema)
+		return "", false
+	}
+	return e.pack, true
+}
+
+func (c *schemaCache) set(schema, pack string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[schema] = cachedSchema{pack: pack, expires: time.Now().Add(c.ttl)}
+}
+
+// forget evicts the schema, i.e. when its types are not found in the registry
+func (c *schemaCache) forget(schema string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, schema)
+}
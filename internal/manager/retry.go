@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedRetries bounds the memory used for tracking the failing messages
+const maxTrackedRetries = 10_000
+
+// retryTracker tracks the delivery attempts of failing messages, to back off on repeated failures of the same message.
+type retryTracker struct {
+	mu       sync.Mutex
+	attempts map[string]int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+func newRetryTracker(delay, maxDelay time.Duration) *retryTracker {
+	if maxDelay < delay {
+		maxDelay = delay
+	}
+	return &retryTracker{
+		attempts: make(map[string]int),
+		delay:    delay,
+		maxDelay: maxDelay,
+	}
+}
+
+// Failed records a failed attempt of the message, and returns the delay before it should be redelivered.
+func (r *retryTracker) Failed(id string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.attempts) >= maxTrackedRetries {
+		r.attempts = make(map[string]int)
+	}
+	r.attempts[id]++
+
+	d := r.delay
+	for i := 1; i < r.attempts[id] && d < r.maxDelay; i++ {
+		d *= 2
+	}
+	if d > r.maxDelay {
+		d = r.maxDelay
+	}
+	return d
+}
+
+// Succeeded stops tracking the message
+func (r *retryTracker) Succeeded(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, id)
+}
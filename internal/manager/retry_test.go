@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"gocloud.dev/pubsub"
+	"testing"
+	"time"
+)
+
+func TestRetryTrackerBacksOffPerMessage(t *testing.T) {
+	r := newRetryTracker(time.Second, 10*time.Second)
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second} {
+		if d := r.Failed("msg-1"); d != want {
+			t.Fatalf("expected a delay of %s, got %s", want, d)
+		}
+	}
+	if d := r.Failed("msg-2"); d != time.Second {
+		t.Errorf("expected the delay of another message to start over, got %s", d)
+	}
+
+	r.Succeeded("msg-1")
+	if d := r.Failed("msg-1"); d != time.Second {
+		t.Errorf("expected the delay of a succeeded message to start over, got %s", d)
+	}
+
+	if d := newRetryTracker(time.Minute, time.Second).Failed("msg-1"); d != time.Minute {
+		t.Errorf("expected the max delay to be at least the delay, got %s", d)
+	}
+}
+
+func TestRetryTrackerIsBounded(t *testing.T) {
+	r := newRetryTracker(time.Second, time.Minute)
+	r.Failed("msg")
+	r.Failed("msg")
+	for i := 1; i < maxTrackedRetries; i++ {
+		r.Failed(fmt.Sprintf("msg-%d", i))
+	}
+	if n := len(r.attempts); n != maxTrackedRetries {
+		t.Fatalf("expected %d tracked messages, got %d", maxTrackedRetries, n)
+	}
+
+	// tracking another message resets the tracked messages
+	if d := r.Failed("msg"); d != time.Second {
+		t.Errorf("expected the delay to start over once the tracker is full, got %s", d)
+	}
+	if n := len(r.attempts); n != 1 {
+		t.Errorf("expected the tracked messages to be reset, got %d", n)
+	}
+}
+
+func TestNack(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	h.bs.retries = newRetryTracker(time.Second, time.Minute)
+	md := brokers.Metadata{ID: "msg-1", Topic: "events"}
+
+	// without delayed redelivery, the message is nacked for immediate redelivery
+	h.send("events", "plain")
+	r := h.receive(1)["plain"]
+	h.m.nack(context.Background(), r, md, h.bs)
+	if _, ok := h.receive(1)["plain"]; !ok {
+		t.Fatal("expected the nacked message to be redelivered")
+	}
+
+	// with delayed redelivery, the delay grows with the message's attempts
+	var delays []time.Duration
+	h.bs.subscriptions[0].NackWithDelay = func(_ context.Context, _ *pubsub.Message, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	h.send("events", "delayed")
+	r = h.receive(1)["delayed"]
+	r.sub = h.bs.subscriptions[0]
+	h.m.nack(context.Background(), r, md, h.bs)
+	h.m.nack(context.Background(), r, md, h.bs)
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("expected the redelivery to be delayed by 1s and 2s, got %v", delays)
+	}
+
+	// without a retry delay, the broker's delayed redelivery isn't used
+	h.bs.retries = nil
+	h.m.nack(context.Background(), r, md, h.bs)
+	if len(delays) != 2 {
+		t.Errorf("expected the message to be nacked without a delay, got %v", delays)
+	}
+	if _, ok := h.receive(1)["delayed"]; !ok {
+		t.Error("expected the nacked message to be redelivered")
+	}
+}
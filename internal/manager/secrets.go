@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"reflect"
+)
+
+// referencesSecret reports whether the DataSource's config references the secret
+func referencesSecret(in *raptorApi.DataSource, secret *corev1.Secret) bool {
+	if in == nil || in.Namespace != secret.Namespace {
+		return false
+	}
+	for _, cv := range in.Spec.Config {
+		if cv.Value == "" && cv.SecretKeyRef != nil && cv.SecretKeyRef.Name == secret.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// secretChanged resubscribes with the rotated credentials when a secret referenced by the config changes
+func (m *manager) secretChanged(ctx context.Context, oldSecret, newSecret *corev1.Secret) {
+	if reflect.DeepEqual(oldSecret.Data, newSecret.Data) {
+		return
+	}
+
+	m.mu.RLock()
+	in := m.in
+	m.mu.RUnlock()
+	if !referencesSecret(in, newSecret) {
+		return
+	}
+
+	m.logger.Info("referenced secret changed, resubscribing...", "secret", newSecret.Name)
+	m.stop()
+	m.Add(ctx, in)
+}
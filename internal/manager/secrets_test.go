@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/base64"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestSecretRotationResubscribes(t *testing.T) {
+	h := newHarness(t, nil, "events")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("old")},
+	}
+	if err := h.kube.Create(h.ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	in := dataSource(map[string]string{"schema": serveSchema(t, "rotation")})
+	in.Spec.Config = append(in.Spec.Config, raptorApi.ConfigVar{
+		Name: "password",
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+			Key:                  "password",
+		},
+	})
+	h.m.Add(h.ctx, in)
+	// the secrets' values are base64 encoded by the config parsing
+	if !h.m.Ready(h.ctx) || h.config()["password"] != base64.StdEncoding.EncodeToString([]byte("old")) {
+		t.Fatalf("expected to subscribe with the secret, got %v (err: %v)", h.config(), h.m.Err())
+	}
+
+	// the resync of an unchanged secret, and the changes of unrelated secrets are ignored
+	h.m.secretChanged(h.ctx, secret, secret.DeepCopy())
+	other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	changed := other.DeepCopy()
+	changed.Data = map[string][]byte{"password": []byte("other")}
+	h.m.secretChanged(h.ctx, other, changed)
+	if n := h.subscribes.Load(); n != 1 {
+		t.Fatalf("expected to resubscribe only on rotation, got %d subscribes", n)
+	}
+
+	rotated := secret.DeepCopy()
+	rotated.Data["password"] = []byte("new")
+	if err := h.kube.Update(h.ctx, rotated); err != nil {
+		t.Fatal(err)
+	}
+	h.m.secretChanged(h.ctx, secret, rotated)
+	if n := h.subscribes.Load(); n != 2 {
+		t.Fatalf("expected the rotation to resubscribe, got %d subscribes", n)
+	}
+	if !h.m.Ready(h.ctx) || h.config()["password"] != base64.StdEncoding.EncodeToString([]byte("new")) {
+		t.Errorf("expected to resubscribe with the rotated secret, got %v (err: %v)", h.config(), h.m.Err())
+	}
+}
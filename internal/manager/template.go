@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// configTemplateData is the data the config templates are rendered with:
+//   - `{{ .Name }}` and `{{ .Namespace }}` of the DataSource
+//   - `{{ .Env.VAR }}` for the runner's environment variables. Missing variables fail the rendering.
+type configTemplateData struct {
+	Name      string
+	Namespace string
+	Env       map[string]string
+}
+
+// renderConfig renders the literal config values of the DataSource as Go templates (i.e. a per-environment broker
+// address as `{{ .Env.KAFKA_BROKERS }}`). Values of Secrets are taken as is.
+// Rendered values that are URLs are validated.
+func renderConfig(in *raptorApi.DataSource, cfg raptorApi.ParsedConfig) error {
+	if cfg == nil {
+		return fmt.Errorf("no config to render")
+	}
+	var data *configTemplateData
+	for _, cv := range in.Spec.Config {
+		if cv.SecretKeyRef != nil || !strings.Contains(cv.Value, "{{") {
+			continue
+		}
+		if data == nil {
+			data = &configTemplateData{Name: in.Name, Namespace: in.Namespace, Env: make(map[string]string)}
+			for _, kv := range os.Environ() {
+				k, v, _ := strings.Cut(kv, "=")
+				data.Env[k] = v
+			}
+		}
+
+		tpl, err := template.New(cv.Name).Option("missingkey=error").Parse(cv.Value)
+		if err != nil {
+			return fmt.Errorf("invalid template of config %s: %w", cv.Name, err)
+		}
+		var sb strings.Builder
+		if err := tpl.Execute(&sb, data); err != nil {
+			return fmt.Errorf("failed to render config %s: %w", cv.Name, err)
+		}
+		v := sb.String()
+		if strings.Contains(v, "://") {
+			if _, err := url.Parse(v); err != nil {
+				return fmt.Errorf("config %s rendered to an invalid url: %w", cv.Name, err)
+			}
+		}
+		cfg[cv.Name] = v
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestAddWithMissingSecret(t *testing.T) {
+	h := newHarness(t, nil)
+	in := &raptorApi.DataSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default"},
+		Spec: raptorApi.DataSourceSpec{
+			Kind: "streaming",
+			Config: []raptorApi.ConfigVar{{
+				Name: "password",
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "missing"},
+					Key:                  "password",
+				},
+			}},
+		},
+	}
+	h.m.Add(context.Background(), in)
+	if h.m.Err() == nil {
+		t.Fatal("expected the missing Secret to be reported")
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	t.Setenv("TEST_BROKERS", "kafka:9092")
+	in := &raptorApi.DataSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default"},
+		Spec: raptorApi.DataSourceSpec{Config: []raptorApi.ConfigVar{
+			{Name: "brokers", Value: "{{ .Env.TEST_BROKERS }}"},
+			{Name: "group", Value: "{{ .Namespace }}-{{ .Name }}"},
+		}},
+	}
+	cfg := raptorApi.ParsedConfig{}
+	if err := renderConfig(in, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg["brokers"] != "kafka:9092" || cfg["group"] != "default-source" {
+		t.Errorf("unexpected rendered config: %v", cfg)
+	}
+
+	in.Spec.Config = []raptorApi.ConfigVar{{Name: "brokers", Value: "{{ .Env.TEST_UNDEFINED }}"}}
+	if err := renderConfig(in, raptorApi.ParsedConfig{}); err == nil {
+		t.Error("expected a missing environment variable to fail the rendering")
+	}
+	if err := renderConfig(in, nil); err == nil {
+		t.Error("expected a nil config to fail the rendering")
+	}
+}
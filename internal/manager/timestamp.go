@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TimestampBroker is the timestamp provided by the broker
+	TimestampBroker = "broker"
+	// TimestampRFC3339 is an RFC 3339 timestamp header
+	TimestampRFC3339 = "rfc3339"
+	// TimestampEpochMillis is a milliseconds since epoch timestamp header
+	TimestampEpochMillis = "epoch_ms"
+	// TimestampEpochSeconds is a seconds since epoch timestamp header
+	TimestampEpochSeconds = "epoch_s"
+)
+
+func validateTimestampFormats(formats []string) error {
+	for _, f := range formats {
+		switch f {
+		case TimestampBroker, TimestampRFC3339, TimestampEpochMillis, TimestampEpochSeconds:
+		default:
+			return fmt.Errorf("unsupported timestamp format: %s", f)
+		}
+	}
+	return nil
+}
+
+// timestamp resolves the message timestamp by trying the formats in order, where the header formats are parsed from
+// the `TimestampHeader` header. It reports false if none of them resolved.
+func (bs BaseStreaming) timestamp(headers map[string]string, md brokers.Metadata) (time.Time, bool) {
+	v := strings.TrimSpace(headers[bs.TimestampHeader])
+	for _, f := range bs.TimestampFormats {
+		if f == TimestampBroker {
+			if !md.Timestamp.IsZero() {
+				return md.Timestamp, true
+			}
+			continue
+		}
+		if v == "" {
+			continue
+		}
+
+		switch f {
+		case TimestampRFC3339:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t, true
+			}
+		case TimestampEpochMillis:
+			if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.UnixMilli(ms), true
+			}
+		case TimestampEpochSeconds:
+			if sec, err := strconv.ParseFloat(v, 64); err == nil {
+				return time.Unix(0, int64(sec*float64(time.Second))), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
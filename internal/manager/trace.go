@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// withTraceContext continues the producer's W3C trace context (the `traceparent` and `tracestate` headers) into the
+// runtime calls, by propagating it in the outgoing gRPC metadata
+func withTraceContext(ctx context.Context, headers map[string]string) context.Context {
+	carrier := propagation.MapCarrier{}
+	for k, v := range headers {
+		switch k := strings.ToLower(k); k {
+		case "traceparent", "tracestate":
+			carrier[k] = v
+		}
+	}
+	if len(carrier) == 0 {
+		return ctx
+	}
+
+	prop := propagation.TraceContext{}
+	ctx = prop.Extract(ctx, carrier)
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	// re-inject the parsed context, so malformed headers aren't forwarded
+	out := propagation.MapCarrier{}
+	prop.Inject(ctx, out)
+	kv := make([]string, 0, 2*len(out))
+	for k, v := range out {
+		kv = append(kv, k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
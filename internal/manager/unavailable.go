@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRuntimeUnavailable is the error of the manager while the runtime is unreachable, and some of the features can't
+// be loaded yet
+var ErrRuntimeUnavailable = errors.New("runtime unavailable")
+
+// maxRuntimeBackoff is the maximum time to back off before retrying to load the features into the runtime
+const maxRuntimeBackoff = time.Minute
+
+// isUnavailable reports whether the error is due to the runtime being unreachable, rather than the feature
+func isUnavailable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+// runtimeGate holds the messages while some features are pending to be loaded into the runtime, so they aren't
+// handled without the pending features
+type runtimeGate struct {
+	pending atomic.Int32
+}
+
+// open reports whether the messages can be handled
+func (g *runtimeGate) open() bool {
+	return g == nil || g.pending.Load() == 0
+}
+
+// loadPending retries loading the features that couldn't be loaded since the runtime was unreachable, with a
+// backoff, until they're all loaded or the context is done
+func (m *manager) loadPending(ctx context.Context, refs []raptorApi.ResourceReference, bs BaseStreaming) {
+	m.setErr(fmt.Errorf("%w: %d feature(s) are pending to be loaded", ErrRuntimeUnavailable, len(refs)))
+	backoff := time.Second
+	for len(refs) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		var failed []raptorApi.ResourceReference
+		for _, ref := range refs {
+			ft, err := m.getFeature(ctx, ref, bs)
+			switch {
+			case err == nil:
+				bs.features.Set(ref.ObjectKey(), ft)
+				m.logger.Info("feature loaded", "feature", ref.Name)
+			case isUnavailable(err):
+				failed = append(failed, ref)
+				continue
+			case !errors.Is(err, errNotSelected):
+				m.logger.Error(err, "failed to fetch feature", "feature", ref.Name)
+			}
+			bs.runtimeGate.pending.Add(-1)
+		}
+		refs = failed
+		backoff = min(backoff*2, maxRuntimeBackoff)
+	}
+	m.setErr(nil)
+	m.logger.Info("runtime is available, all features are loaded")
+}
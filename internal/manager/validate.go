@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
+)
+
+// Validate validates a DataSource and its features using the same parsing as the runtime, without connecting to
+// the broker, the runtime or the schema registry.
+// The config should be parsed in advance, since parsing may require reading Secrets.
+func Validate(in *raptorApi.DataSource, cfg raptorApi.ParsedConfig, features []*raptorApi.Feature) []error {
+	var errs []error
+	if in.Spec.Kind != "streaming" {
+		errs = append(errs, fmt.Errorf("unsupported DataSource kind: %s", in.Spec.Kind))
+	}
+
+	if err := renderConfig(in, cfg); err != nil {
+		return append(errs, err)
+	}
+
+	bs, _, err := parseBaseStreaming(cfg)
+	if err != nil {
+		return append(errs, err)
+	}
+
+	if _, err := dataSourceMetadata(in.Labels, in.Annotations, bs); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, ft := range features {
+		if _, err := parseFeature(ft, bs); err != nil {
+			errs = append(errs, fmt.Errorf("feature %s/%s: %w", ft.Namespace, ft.Name, err))
+		}
+	}
+	return errs
+}
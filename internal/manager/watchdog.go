@@ -0,0 +1,58 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// watchdog resubscribes when a subscription hasn't received any message for the idle timeout, since some broker
+// drivers may silently wedge (i.e. the connection is alive, but nothing is delivered). Time spent paused doesn't count.
+func (m *manager) watchdog(ctx, parent context.Context, bs BaseStreaming) {
+	active := time.Now()
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if m.Paused() {
+			active = time.Now()
+			continue
+		}
+		for _, sub := range bs.subscriptions {
+			if slices.Contains(bs.IdleTopics, sub.Topic) {
+				continue
+			}
+			last := active
+			if t, ok := m.lastReceive.Load(sub.Topic); ok && t.(time.Time).After(last) {
+				last = t.(time.Time)
+			}
+			if idle := time.Since(last); idle > m.idleTimeout {
+				m.logger.Info("no messages received for too long, resubscribing", "topic", sub.Topic, "idle", idle)
+				idleResubscribes.WithLabelValues(sub.Topic).Inc()
+				m.resubscribe(ctx, parent)
+				return
+			}
+		}
+	}
+}
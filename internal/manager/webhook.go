@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// OutcomeSuccess is the outcome of a message that was handled successfully
+	OutcomeSuccess = "success"
+	// OutcomeFailure is the outcome of a message that failed, and is redelivered
+	OutcomeFailure = "failure"
+	// OutcomeDropped is the outcome of a message that failed permanently, and was dropped
+	OutcomeDropped = "dropped"
+)
+
+// WebhookSignatureHeader is the header of the webhook body's HMAC-SHA256 signature, as `sha256=<hex>`
+const WebhookSignatureHeader = "X-Signature-256"
+
+// Webhook notifies an HTTP(S) endpoint of the outcome of each handled message.
+// Notifications are fire-and-forget: they're sent by at most `Concurrency` requests at a time, and dropped when the
+// endpoint can't keep up, so they never stall handling the messages. When `Secret` is set, the body is signed.
+type Webhook struct {
+	URL         string        `mapstructure:"webhook_url"`
+	Secret      string        `mapstructure:"webhook_secret"`
+	Timeout     time.Duration `mapstructure:"webhook_timeout"`
+	Concurrency int           `mapstructure:"webhook_concurrency"`
+}
+
+// webhookEvent is the body of the webhook notifications
+type webhookEvent struct {
+	ID      string   `json:"id"`
+	Topic   string   `json:"topic"`
+	FQNs    []string `json:"fqns"`
+	Outcome string   `json:"outcome"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type notifier struct {
+	Webhook
+	client *http.Client
+	sem    chan struct{}
+	ctx    context.Context
+	logger logr.Logger
+}
+
+func (w Webhook) validate() error {
+	if w.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported webhook url scheme: %s", u.Scheme)
+	}
+	if w.Concurrency < 0 {
+		return fmt.Errorf("invalid webhook concurrency: %d", w.Concurrency)
+	}
+	return nil
+}
+
+// newNotifier creates the webhook notifier. Pending notifications are abandoned once the context is done.
+func newNotifier(ctx context.Context, w Webhook, logger logr.Logger) *notifier {
+	if w.URL == "" {
+		return nil
+	}
+	if w.Timeout == 0 {
+		w.Timeout = 5 * time.Second
+	}
+	if w.Concurrency == 0 {
+		w.Concurrency = 10
+	}
+	return &notifier{
+		Webhook: w,
+		client:  &http.Client{Timeout: w.Timeout},
+		sem:     make(chan struct{}, w.Concurrency),
+		ctx:     ctx,
+		logger:  logger,
+	}
+}
+
+// notify sends the outcome of the message in the background, unless too many notifications are in flight
+func (n *notifier) notify(md brokers.Metadata, fqns []string, err error) {
+	ev := webhookEvent{ID: md.ID, Topic: md.Topic, FQNs: fqns, Outcome: OutcomeSuccess}
+	if err != nil {
+		ev.Outcome = OutcomeFailure
+		if isPermanent(err) {
+			ev.Outcome = OutcomeDropped
+		}
+		ev.Error = err.Error()
+	}
+
+	select {
+	case n.sem <- struct{}{}:
+	default:
+		webhookDropped.Inc()
+		return
+	}
+	go func() {
+		defer func() { <-n.sem }()
+		if err := n.send(ev); err != nil && n.ctx.Err() == nil {
+			n.logger.Error(err, "failed to send webhook notification", "msgID", ev.ID)
+		}
+	}()
+}
+
+func (n *notifier) send(ev webhookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
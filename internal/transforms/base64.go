@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"github.com/raptor-ml/streaming-runner/pkg/transforms"
+)
+
+func init() {
+	transforms.Register("base64", newBase64)
+}
+
+// newBase64 decodes a base64 encoded body.
+// The argument selects the encoding: `std` (default), `url`, `raw` or `rawurl`.
+func newBase64(arg string) (transforms.Transformer, error) {
+	var enc *base64.Encoding
+	switch arg {
+	case "", "std":
+		enc = base64.StdEncoding
+	case "url":
+		enc = base64.URLEncoding
+	case "raw":
+		enc = base64.RawStdEncoding
+	case "rawurl":
+		enc = base64.RawURLEncoding
+	default:
+		return nil, fmt.Errorf("unsupported base64 encoding: %s", arg)
+	}
+
+	return func(_ context.Context, body []byte, _ *brokers.Metadata) ([]byte, error) {
+		ret := make([]byte, enc.DecodedLen(len(body)))
+		n, err := enc.Decode(ret, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 body: %w", err)
+		}
+		return ret[:n], nil
+	}, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"github.com/raptor-ml/streaming-runner/pkg/transforms"
+	"strings"
+)
+
+func init() {
+	transforms.Register("json_envelope", newJSONEnvelope)
+}
+
+// newJSONEnvelope unwraps a JSON envelope, replacing the body with the value of a nested field.
+// The argument is the dot-separated path of the field (defaults to `data`). String values are used as-is, while
+// any other value is re-encoded as JSON.
+func newJSONEnvelope(arg string) (transforms.Transformer, error) {
+	if arg == "" {
+		arg = "data"
+	}
+	path := strings.Split(arg, ".")
+
+	return func(_ context.Context, body []byte, _ *brokers.Metadata) ([]byte, error) {
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal envelope: %w", err)
+		}
+		for _, p := range path {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("envelope field `%s` is not an object", p)
+			}
+			if v, ok = m[p]; !ok {
+				return nil, fmt.Errorf("envelope field `%s` is missing", arg)
+			}
+		}
+
+		if s, ok := v.(string); ok {
+			return []byte(s), nil
+		}
+		return json.Marshal(v)
+	}, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"github.com/raptor-ml/streaming-runner/pkg/transforms"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	body := "{\"id\": \"user-1\"}\xff"
+	tests := []struct {
+		name     string
+		spec     string
+		body     string
+		want     string
+		parseErr bool
+		applyErr bool
+	}{
+		{name: "unknown transform", spec: "base32", parseErr: true},
+
+		{name: "base64 std (default)", spec: "base64", body: base64.StdEncoding.EncodeToString([]byte(body)), want: body},
+		{name: "base64 std", spec: "base64:std", body: base64.StdEncoding.EncodeToString([]byte(body)), want: body},
+		{name: "base64 url", spec: "base64:url", body: base64.URLEncoding.EncodeToString([]byte(body)), want: body},
+		{name: "base64 raw", spec: "base64:raw", body: base64.RawStdEncoding.EncodeToString([]byte(body)), want: body},
+		{name: "base64 rawurl", spec: "base64:rawurl", body: base64.RawURLEncoding.EncodeToString([]byte(body)),
+			want: body},
+		{name: "base64 unsupported encoding", spec: "base64:hex", parseErr: true},
+		{name: "base64 invalid input", spec: "base64", body: "not base64!", applyErr: true},
+		{name: "base64 url of std input", spec: "base64:url", body: "+/8=", applyErr: true},
+		{name: "base64 raw of padded input", spec: "base64:raw", body: "YQ==", applyErr: true},
+
+		{name: "json_envelope default path", spec: "json_envelope", body: `{"data": {"id": "user-1"}}`,
+			want: `{"id":"user-1"}`},
+		{name: "json_envelope dot path", spec: "json_envelope:payload.after",
+			body: `{"payload": {"after": {"id": "user-1"}}}`, want: `{"id":"user-1"}`},
+		{name: "json_envelope string value", spec: "json_envelope", body: `{"data": "{\"id\": \"user-1\"}"}`,
+			want: `{"id": "user-1"}`},
+		{name: "json_envelope missing path", spec: "json_envelope:payload.after", body: `{"payload": {}}`,
+			applyErr: true},
+		{name: "json_envelope path through a value", spec: "json_envelope:payload.after", body: `{"payload": 1}`,
+			applyErr: true},
+		{name: "json_envelope non-JSON body", spec: "json_envelope", body: "data", applyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := transforms.Parse([]string{tt.spec})
+			if (err != nil) != tt.parseErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.parseErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := p.Apply(context.Background(), []byte(tt.body), &brokers.Metadata{})
+			if (err != nil) != tt.applyErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.applyErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,23 +18,67 @@ package brokers
 
 import (
 	"context"
+	"encoding/base64"
 	raptorApi "github.com/raptor-ml/raptor/api/v1alpha1"
 	"gocloud.dev/pubsub"
 	"time"
+	"unicode/utf8"
 )
 
 type Metadata struct {
 	Topic     string
 	Timestamp time.Time
 	ID        string
+
+	// Key is the broker's message key (i.e. the Kafka record key), if any. Binary keys are base64 encoded (see
+	// EncodeKey), so they can be used as strings (i.e. as the entity id) without being corrupted.
+	Key string
+
+	// KeyBytes is the raw message key, for brokers with binary keys
+	KeyBytes []byte
+
+	// Attempts is the delivery attempt of the message, starting at 1, if the broker tracks it (0 otherwise)
+	Attempts int
+
+	// TimestampFallback reports the message has no timestamp, and Timestamp is the time it was processed at instead
+	TimestampFallback bool
+}
+
+// EncodeKey returns the key as a string: text (valid UTF-8) keys as is, and binary keys base64 encoded
+func EncodeKey(key []byte) string {
+	if utf8.Valid(key) {
+		return string(key)
+	}
+	return base64.StdEncoding.EncodeToString(key)
 }
 
 type MetadataExtractor func(ctx context.Context, msg *pubsub.Message) Metadata
 type Unmarshaler func(any) error
 
+// Subscription is a subscription opened by a broker.
+type Subscription struct {
+	*pubsub.Subscription
+
+	// Topic is the topic this subscription consumes from. When a subscription consumes from multiple topics, this
+	// should be left empty and the broker's Metadata extractor is responsible for populating the topic per message.
+	Topic string
+
+	// NackWithDelay nacks the message, so it becomes visible again only after the delay has passed.
+	// It's nil for subscriptions that don't support delayed redelivery.
+	NackWithDelay func(ctx context.Context, msg *pubsub.Message, delay time.Duration) error
+
+	// ExtendDeadline extends the ack deadline of the message to `d` from now, so a slow handling isn't redelivered.
+	// It's nil for subscriptions without an ack deadline.
+	ExtendDeadline func(ctx context.Context, msg *pubsub.Message, d time.Duration) error
+
+	// Ping verifies the subscription is reachable, without consuming messages.
+	// It's nil for subscriptions that can't be verified.
+	Ping func(ctx context.Context) error
+}
+
 type Broker interface {
 	Metadata(context.Context, *pubsub.Message) Metadata
-	Subscribe(context.Context, raptorApi.ParsedConfig) (context.Context, *pubsub.Subscription, error)
+	Subscribe(context.Context, raptorApi.ParsedConfig) (context.Context, []Subscription, error)
 }
 
 type ctxKey string
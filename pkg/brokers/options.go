@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package brokers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubscriptionOptions are driver-specific options of the subscription, configured as a list of `key=value` pairs
+// using the `subscription_options` config. Each broker documents the keys it supports.
+type SubscriptionOptions map[string]string
+
+// ParseSubscriptionOptions parses a list of `key=value` pairs
+func ParseSubscriptionOptions(pairs []string) (SubscriptionOptions, error) {
+	opts := make(SubscriptionOptions)
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid subscription option `%s`: expected `key=value`", p)
+		}
+		opts[k] = v
+	}
+	return opts, nil
+}
+
+// Apply calls the setter of each option, and warns about unknown options.
+func (o SubscriptionOptions) Apply(ctx context.Context, setters map[string]func(string) error) error {
+	for k, v := range o {
+		set, ok := setters[k]
+		if !ok {
+			logr.FromContextOrDiscard(ctx).Info("ignoring unknown subscription option", "option", k)
+			continue
+		}
+		if err := set(v); err != nil {
+			return fmt.Errorf("invalid subscription option %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// IntOption returns a setter that parses an int option into dst
+func IntOption(dst *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*dst = i
+		return nil
+	}
+}
+
+// Int32Option returns a setter that parses an int32 option into dst
+func Int32Option(dst *int32) func(string) error {
+	return func(v string) error {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		*dst = int32(i)
+		return nil
+	}
+}
+
+// DurationOption returns a setter that parses a duration option into dst
+func DurationOption(dst *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*dst = d
+		return nil
+	}
+}
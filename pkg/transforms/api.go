@@ -0,0 +1,29 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"context"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+)
+
+// Transformer is a preprocessing step that is applied to the message body (and metadata) before it's being handled.
+type Transformer func(ctx context.Context, body []byte, md *brokers.Metadata) ([]byte, error)
+
+// Factory creates a Transformer given its argument.
+// The argument is the part after the `:` in the transform spec (i.e. `json_envelope:payload`), and may be empty.
+type Factory func(arg string) (Transformer, error)
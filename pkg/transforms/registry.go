@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"context"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"strings"
+)
+
+// # Transforms registry
+var transforms = make(map[string]Factory)
+
+// Register registers a transform
+func Register(name string, f Factory) {
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Errorf("transform `%s` is already registered", name))
+	}
+	transforms[name] = f
+}
+
+// Get retrieves a transform factory
+func Get(name string) Factory {
+	return transforms[name]
+}
+
+// Pipeline is an ordered list of transformers
+type Pipeline []Transformer
+
+// Parse builds a Pipeline out of an ordered list of transform specs.
+// A spec is either the name of a registered transform, or `name:arg`.
+func Parse(specs []string) (Pipeline, error) {
+	var p Pipeline
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(spec, ":")
+		f := Get(name)
+		if f == nil {
+			return nil, fmt.Errorf("transform `%s` not found", name)
+		}
+		t, err := f(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transform `%s`: %w", name, err)
+		}
+		p = append(p, t)
+	}
+	return p, nil
+}
+
+// Apply runs the transformers in order, feeding each one with the output of the previous one
+func (p Pipeline) Apply(ctx context.Context, body []byte, md *brokers.Metadata) ([]byte, error) {
+	var err error
+	for i, t := range p {
+		body, err = t(ctx, body, md)
+		if err != nil {
+			return nil, fmt.Errorf("transform #%d failed: %w", i, err)
+		}
+	}
+	return body, nil
+}
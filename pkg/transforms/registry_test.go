@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2022 RaptorML authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transforms
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/raptor-ml/streaming-runner/pkg/brokers"
+	"testing"
+)
+
+func init() {
+	Register("test_upper", func(string) (Transformer, error) {
+		return func(_ context.Context, body []byte, _ *brokers.Metadata) ([]byte, error) {
+			return bytes.ToUpper(body), nil
+		}, nil
+	})
+	Register("test_suffix", func(arg string) (Transformer, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("missing suffix")
+		}
+		return func(_ context.Context, body []byte, _ *brokers.Metadata) ([]byte, error) {
+			if len(body) == 0 {
+				return nil, fmt.Errorf("empty body")
+			}
+			return append(body, arg...), nil
+		}, nil
+	})
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    int
+		wantErr bool
+	}{
+		{"empty", nil, 0, false},
+		{"blank specs are skipped", []string{" ", ""}, 0, false},
+		{"registered", []string{"test_upper", " test_suffix:! "}, 2, false},
+		{"unknown transform", []string{"test_upper", "unknown"}, 0, true},
+		{"invalid argument", []string{"test_suffix"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(p) != tt.want {
+				t.Errorf("Parse() = %d transformers, want %d", len(p), tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"no transforms", nil, "body", "body", false},
+		{"in order", []string{"test_suffix:!", "test_upper"}, "body", "BODY!", false},
+		{"failing transform", []string{"test_upper", "test_suffix:!"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.specs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Apply(context.Background(), []byte(tt.body), &brokers.Metadata{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}